@@ -0,0 +1,117 @@
+// Package agent keeps semaphores held on behalf of short-lived commands, so
+// that a hold can span several of them without being orphaned.  The agent
+// keeps its Consul clients and sessions between requests, and serves
+// HTTP with JSON bodies over a Unix socket.  Client speaks to it.
+//
+// Holds, and their TTLs, live in the agent's memory, and each is tied to a
+// Consul session the agent keeps renewing.  If the agent dies without
+// releasing them, its session expires, and the next acquire that finds the
+// semaphore exhausted evicts them.
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// SocketEnv names the environment variable overriding DefaultSocket.
+const SocketEnv = "CONSUL_SEMAPHORE_AGENT"
+
+// DefaultSocket returns the socket path the agent listens on unless told
+// otherwise: $CONSUL_SEMAPHORE_AGENT, or a socket in $XDG_RUNTIME_DIR, or
+// failing that, in a per-user directory in the temporary directory.
+func DefaultSocket() string {
+	if socket := os.Getenv(SocketEnv); socket != "" {
+		return socket
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); dir != "" {
+		return filepath.Join(dir, "consul-semaphore.sock")
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("consul-semaphore-%d", os.Getuid()), "agent.sock")
+}
+
+// ErrUntrusted is returned for a socket, or a directory for one, that
+// belongs to another user or that other users may write to, as whoever
+// listens there could answer for the agent.
+var ErrUntrusted = errors.New("socket is not private to this user")
+
+// private returns ErrUntrusted unless path belongs to the current user and,
+// if a directory, only they may write to it.
+func private(path string) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if !privateToUser(fi) {
+		return fmt.Errorf("%s: %w", path, ErrUntrusted)
+	}
+	return nil
+}
+
+// Request names a semaphore and holder, along with how to go about the
+// operation asked for.
+type Request struct {
+	Consul string `json:"consul"`
+	Path   string `json:"path"`
+	Holder string `json:"holder"`
+
+	// Wait and WaitTimeout are as for semaphore.Acquire and AcquireTimeout.
+	Wait        bool          `json:"wait,omitempty"`
+	WaitTimeout time.Duration `json:"waitTimeout,omitempty"`
+
+	// TTL, if set, is how long a hold lasts unless renewed.
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// Consistent reads status through the Consul leader.
+	Consistent bool `json:"consistent,omitempty"`
+}
+
+// Hold is a semaphore held through the agent.
+type Hold struct {
+	Consul   string     `json:"consul"`
+	Path     string     `json:"path"`
+	Holder   string     `json:"holder"`
+	Acquired time.Time  `json:"acquired"`
+	Expires  *time.Time `json:"expires,omitempty"`
+}
+
+// Kinds of Error, standing in for the errors they were made from.  They
+// are the names of the errors' semaphore.Class.
+const (
+	KindExhausted   = string(semaphore.ClassExhausted)
+	KindTimeout     = string(semaphore.ClassTimeout)
+	KindUnreachable = string(semaphore.ClassUnreachable)
+	KindNotHeld     = string(semaphore.ClassNotHeld)
+	KindOther       = string(semaphore.ClassOther)
+)
+
+// Error is a failure reported by the agent.
+type Error struct {
+	Kind    string `json:"kind"`
+	Message string `json:"error"`
+}
+
+func (e Error) Error() string {
+	return e.Message
+}
+
+// NewError describes err for the agent's clients, or any other client of
+// consul-semaphore over HTTP.
+func NewError(err error) Error {
+	if e, ok := err.(Error); ok {
+		return e
+	}
+
+	kind := KindOther
+	switch c := semaphore.Classify(err); c {
+	case semaphore.ClassExhausted, semaphore.ClassTimeout, semaphore.ClassUnreachable, semaphore.ClassNotHeld:
+		kind = string(c)
+	}
+
+	return Error{Kind: kind, Message: err.Error()}
+}
@@ -0,0 +1,104 @@
+package agent
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		err  error
+		kind string
+	}{
+		{lock.SemaphoreExhaustedErr(2), KindExhausted},
+		{lock.ErrFrozen, KindExhausted},
+		{semaphore.GateClosedErr{Reason: "web failing"}, KindExhausted},
+		{semaphore.ErrAcquireTimeout, KindTimeout},
+		{lock.ErrNotExist, KindNotHeld},
+		{errors.New("boom"), KindOther},
+	}
+
+	for _, test := range tests {
+		got := NewError(test.err)
+		if got.Kind != test.kind || got.Message != test.err.Error() {
+			t.Errorf("%v: got %+v, want kind %s", test.err, got, test.kind)
+		}
+	}
+}
+
+func TestServeRenewNotHeld(t *testing.T) {
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- NewServer(socket, nil).Serve(ctx)
+	}()
+
+	var c *Client
+	for i := 0; i < 100; i++ {
+		if c, err = Dial(socket); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("agent never listened: %v", err)
+	}
+
+	if err := NewServer(socket, nil).Serve(ctx); err != ErrRunning {
+		t.Errorf("second agent: got %v, want %v", err, ErrRunning)
+	}
+
+	_, err = c.Renew(Request{Consul: "127.0.0.1:8500", Path: "test/semaphore", Holder: "a"})
+	if e, ok := err.(Error); !ok || e.Kind != KindNotHeld {
+		t.Errorf("renew without a hold: got %#v, want a %s Error", err, KindNotHeld)
+	}
+
+	cancel()
+	if err := <-served; err != nil {
+		t.Errorf("Serve: %v", err)
+	}
+	if _, err := os.Stat(socket); !os.IsNotExist(err) {
+		t.Errorf("socket left behind: %v", err)
+	}
+}
+
+func TestServeSharedDir(t *testing.T) {
+	dir, err := os.MkdirTemp("", "agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := os.Chmod(dir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	err = NewServer(filepath.Join(dir, "agent.sock"), nil).Serve(context.Background())
+	if !errors.Is(err, ErrUntrusted) {
+		t.Errorf("Serve in a shared directory: %v, want %v", err, ErrUntrusted)
+	}
+
+	// a per-user directory is created private
+	socket := filepath.Join(dir, "user", "agent.sock")
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := NewServer(socket, nil).Serve(ctx); err != nil {
+		t.Errorf("Serve in a new directory: %v", err)
+	}
+	if err := private(filepath.Dir(socket)); err != nil {
+		t.Errorf("new directory: %v", err)
+	}
+}
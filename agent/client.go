@@ -0,0 +1,111 @@
+package agent
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+// dialTimeout bounds connecting to the agent's socket.
+const dialTimeout = time.Second
+
+// Client talks to an agent over its socket.
+type Client struct {
+	Socket string
+
+	http *http.Client
+}
+
+// Dial returns a Client for the agent listening on socket, failing if no
+// agent is, or with ErrUntrusted if the socket belongs to another user.
+func Dial(socket string) (*Client, error) {
+	if err := private(socket); err != nil {
+		return nil, err
+	}
+
+	conn, err := net.DialTimeout("unix", socket, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	conn.Close()
+
+	dial := func(ctx context.Context, _, _ string) (net.Conn, error) {
+		d := net.Dialer{Timeout: dialTimeout}
+		return d.DialContext(ctx, "unix", socket)
+	}
+	return &Client{
+		Socket: socket,
+		http:   &http.Client{Transport: &http.Transport{DialContext: dial}},
+	}, nil
+}
+
+// Acquire acquires the semaphore through the agent, which holds it until
+// Release, or until req.TTL passes without a Renew.
+func (c *Client) Acquire(req Request) (*Hold, error) {
+	var h Hold
+	if err := c.call("acquire", req, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Release releases the semaphore through the agent, whether or not it was
+// acquired through it.
+func (c *Client) Release(req Request) error {
+	return c.call("release", req, nil)
+}
+
+// Renew restarts the TTL of a hold, with req.TTL if set.
+func (c *Client) Renew(req Request) (*Hold, error) {
+	var h Hold
+	if err := c.call("renew", req, &h); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+// Status returns the state of the semaphore, without creating it.
+func (c *Client) Status(req Request) (*lock.Semaphore, error) {
+	var sem lock.Semaphore
+	if err := c.call("status", req, &sem); err != nil {
+		return nil, err
+	}
+	return &sem, nil
+}
+
+// call posts req to the agent's op endpoint, decoding the answer into
+// result, or returning the agent's Error.
+func (c *Client) call(op string, req Request, result interface{}) error {
+	b, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.http.Post("http://agent/v1/"+op, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		if result == nil {
+			return nil
+		}
+		return json.NewDecoder(resp.Body).Decode(result)
+	case http.StatusConflict:
+		var e Error
+		if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+			return err
+		}
+		return e
+	}
+
+	return fmt.Errorf("agent answered %s", resp.Status)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+// +build !windows
+
+package agent
+
+import (
+	"os"
+	"syscall"
+)
+
+// privateToUser reports whether fi belongs to the current user and, if a
+// directory, only they may write to it.
+func privateToUser(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || int(st.Uid) != os.Getuid() {
+		return false
+	}
+	return !fi.IsDir() || fi.Mode().Perm()&0022 == 0
+}
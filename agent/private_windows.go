@@ -0,0 +1,9 @@
+package agent
+
+import "os"
+
+// privateToUser reports true, as the default socket is in the user's own
+// profile on Windows, which other users can't write to.
+func privateToUser(fi os.FileInfo) bool {
+	return true
+}
@@ -0,0 +1,424 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/backoff"
+	"github.com/ryanschneider/consul-semaphore/logging"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// ErrRunning is returned by Serve when another agent has the socket.
+var ErrRunning = errors.New("an agent is already listening on the socket")
+
+// SessionTTL is the TTL of the Consul sessions holds are tied to.  The
+// agent renews them at half that.
+const SessionTTL = 30 * time.Second
+
+// Server is the agent.  It keeps a Consul client and session per address,
+// and which semaphores it has set up, so each is only set up once, and
+// releases holds whose TTL runs out.
+type Server struct {
+	Socket string
+
+	policy      backoff.Policy
+	log         logging.Logger
+	mu          sync.Mutex
+	clients     map[string]*api.Client
+	initialized map[key]bool
+	holds       map[key]*hold
+
+	// sessionMu guards sessions, which are created and renewed without
+	// holding up requests that don't need them.
+	sessionMu sync.Mutex
+	sessions  map[string]string
+}
+
+// key identifies a semaphore and holder.
+type key struct {
+	consul, path, holder string
+}
+
+type hold struct {
+	Hold
+	ttl    time.Duration
+	expiry *time.Timer
+}
+
+// NewServer returns an agent that will listen on socket, retrying its
+// semaphore operations according to policy, or backoff.Default if nil.
+func NewServer(socket string, policy backoff.Policy) *Server {
+	return &Server{
+		Socket:      socket,
+		policy:      policy,
+		log:         logging.Default(),
+		clients:     make(map[string]*api.Client),
+		initialized: make(map[key]bool),
+		holds:       make(map[key]*hold),
+		sessions:    make(map[string]string),
+	}
+}
+
+// Serve answers requests on the socket until ctx is done, then releases
+// every hold still open and destroys its Consul sessions.  A socket left
+// behind by an agent that is no longer running is replaced.  The socket's
+// directory is created if need be, and must be private to the user, so no
+// one else can take the socket's place.
+func (s *Server) Serve(ctx context.Context) error {
+	dir := filepath.Dir(s.Socket)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := private(dir); err != nil {
+		return err
+	}
+
+	if _, err := Dial(s.Socket); err == nil {
+		return ErrRunning
+	}
+	os.Remove(s.Socket)
+
+	l, err := net.Listen("unix", s.Socket)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(s.Socket)
+	if err := os.Chmod(s.Socket, 0600); err != nil {
+		l.Close()
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/acquire", s.handle(s.acquire))
+	mux.HandleFunc("/v1/release", s.handle(s.release))
+	mux.HandleFunc("/v1/renew", s.handle(s.renew))
+	mux.HandleFunc("/v1/status", s.handle(s.status))
+
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	go s.renewSessions(ctx)
+
+	err = srv.Serve(l)
+	s.releaseAll()
+	s.destroySessions()
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// handle adapts an operation to HTTP, decoding its Request and encoding
+// its result or Error.
+func (s *Server) handle(op func(context.Context, Request) (interface{}, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		var req Request
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		result, err := op(r.Context(), req)
+		if err != nil {
+			w.WriteHeader(http.StatusConflict)
+			json.NewEncoder(w).Encode(NewError(err))
+			return
+		}
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// semaphore returns a Semaphore for req.  Each request gets its own, as a
+// Semaphore keeps per-acquire state, but only the first for a path creates
+// the semaphore in Consul if need be.  That talks to Consul, so is done
+// without s.mu held, lest a slow Consul hold up every other request.
+func (s *Server) semaphore(req Request) (*semaphore.Semaphore, error) {
+	k := key{req.Consul, req.Path, ""}
+
+	s.mu.Lock()
+	initialized := s.initialized[k]
+	client, err := s.client(req.Consul)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	var sem *semaphore.Semaphore
+	if initialized {
+		sem, err = semaphore.Open(req.Path, req.Holder, client)
+	} else {
+		sem, err = semaphore.NewWithClient(req.Path, req.Holder, client)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sem.SetBackoff(s.policy)
+
+	s.mu.Lock()
+	s.initialized[k] = true
+	s.mu.Unlock()
+	return sem, nil
+}
+
+// client returns the Consul client for address.  s.mu must be held.
+func (s *Server) client(address string) (*api.Client, error) {
+	if client, ok := s.clients[address]; ok {
+		return client, nil
+	}
+
+	config := api.DefaultConfig()
+	config.Address = address
+	client, err := api.NewClient(config)
+	if err != nil {
+		return nil, err
+	}
+
+	s.clients[address] = client
+	return client, nil
+}
+
+// session returns the Consul session for address, creating it the first
+// time.
+func (s *Server) session(address string) (string, error) {
+	s.mu.Lock()
+	client, err := s.client(address)
+	s.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+	if id, ok := s.sessions[address]; ok {
+		return id, nil
+	}
+
+	id, _, err := client.Session().Create(&api.SessionEntry{
+		Name: "consul-semaphore agent",
+		TTL:  SessionTTL.String(),
+	}, nil)
+	if err != nil {
+		return "", err
+	}
+	s.sessions[address] = id
+	s.log.Log(logging.Info, "agent session created", logging.F("consul", address), logging.F("session", id))
+	return id, nil
+}
+
+// renewSessions renews every session at half its TTL until ctx is done.
+// A session found expired is forgotten, so the next acquire creates
+// another, but holds made under it may already have been evicted.
+func (s *Server) renewSessions(ctx context.Context) {
+	ticker := time.NewTicker(SessionTTL / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		s.sessionMu.Lock()
+		for address, id := range s.sessions {
+			s.mu.Lock()
+			client := s.clients[address]
+			s.mu.Unlock()
+
+			entry, _, err := client.Session().Renew(id, nil)
+			if err != nil {
+				s.log.Log(logging.Warn, "agent session renewal failed",
+					logging.F("consul", address), logging.F("session", id), logging.F("error", err))
+				continue
+			}
+			if entry == nil {
+				s.log.Log(logging.Error, "agent session expired, its holds may be evicted",
+					logging.F("consul", address), logging.F("session", id))
+				delete(s.sessions, address)
+			}
+		}
+		s.sessionMu.Unlock()
+	}
+}
+
+// destroySessions destroys every session, as the agent is going away.
+func (s *Server) destroySessions() {
+	s.sessionMu.Lock()
+	defer s.sessionMu.Unlock()
+
+	for address, id := range s.sessions {
+		s.mu.Lock()
+		client := s.clients[address]
+		s.mu.Unlock()
+
+		if _, err := client.Session().Destroy(id, nil); err != nil {
+			s.log.Log(logging.Error, "agent session destroy failed",
+				logging.F("consul", address), logging.F("session", id), logging.F("error", err))
+		}
+		delete(s.sessions, address)
+	}
+}
+
+func (s *Server) acquire(ctx context.Context, req Request) (interface{}, error) {
+	sem, err := s.semaphore(req)
+	if err != nil {
+		return nil, err
+	}
+	session, err := s.session(req.Consul)
+	if err != nil {
+		return nil, err
+	}
+	sem.SetSession(session)
+
+	wait := req.Wait
+	if req.WaitTimeout > 0 {
+		wait = true
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, req.WaitTimeout)
+		defer cancel()
+	}
+
+	// ctx is cancelled if the client goes away while waiting
+	if err := sem.AcquireContext(ctx, wait); err != nil {
+		return nil, err
+	}
+
+	k := key{req.Consul, req.Path, req.Holder}
+	h := &hold{Hold: Hold{req.Consul, req.Path, req.Holder, time.Now(), nil}, ttl: req.TTL}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.holds[k] = h
+	s.expireAfter(k, h)
+	s.log.Log(logging.Info, "agent acquired", logging.F("path", req.Path), logging.F("holder", req.Holder))
+	return h.Hold, nil
+}
+
+// expireAfter releases h once its TTL runs out.  s.mu must be held.
+func (s *Server) expireAfter(k key, h *hold) {
+	if h.expiry != nil {
+		h.expiry.Stop()
+	}
+	if h.ttl <= 0 {
+		h.Expires = nil
+		return
+	}
+
+	expires := time.Now().Add(h.ttl)
+	h.Expires = &expires
+	h.expiry = time.AfterFunc(h.ttl, func() {
+		s.mu.Lock()
+		current := s.holds[k] == h
+		if current {
+			delete(s.holds, k)
+		}
+		s.mu.Unlock()
+
+		if current {
+			s.log.Log(logging.Warn, "agent hold expired, releasing",
+				logging.F("path", k.path), logging.F("holder", k.holder))
+			s.releaseHold(k)
+		}
+	})
+}
+
+func (s *Server) release(ctx context.Context, req Request) (interface{}, error) {
+	k := key{req.Consul, req.Path, req.Holder}
+
+	s.mu.Lock()
+	if h, ok := s.holds[k]; ok {
+		if h.expiry != nil {
+			h.expiry.Stop()
+		}
+		delete(s.holds, k)
+	}
+	s.mu.Unlock()
+
+	// the semaphore may have been acquired without the agent, so release
+	// it regardless
+	sem, err := s.semaphore(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := sem.Release(); err != nil {
+		return nil, err
+	}
+
+	s.log.Log(logging.Info, "agent released", logging.F("path", req.Path), logging.F("holder", req.Holder))
+	return struct{}{}, nil
+}
+
+func (s *Server) renew(ctx context.Context, req Request) (interface{}, error) {
+	k := key{req.Consul, req.Path, req.Holder}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	h, ok := s.holds[k]
+	if !ok {
+		return nil, Error{KindNotHeld, "not held through the agent"}
+	}
+	if req.TTL > 0 {
+		h.ttl = req.TTL
+	}
+	s.expireAfter(k, h)
+	return h.Hold, nil
+}
+
+func (s *Server) status(ctx context.Context, req Request) (interface{}, error) {
+	s.mu.Lock()
+	client, err := s.client(req.Consul)
+	s.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	return semaphore.Inspect(client, req.Path, req.Consistent)
+}
+
+// releaseHold releases the semaphore held under k, logging any failure as
+// there is no one left to report it to.
+func (s *Server) releaseHold(k key) {
+	sem, err := s.semaphore(Request{Consul: k.consul, Path: k.path, Holder: k.holder})
+	if err == nil {
+		err = sem.Release()
+	}
+	if err != nil {
+		s.log.Log(logging.Error, "agent release failed",
+			logging.F("path", k.path), logging.F("holder", k.holder), logging.F("error", err))
+	}
+}
+
+// releaseAll releases every open hold, as the agent is going away.
+func (s *Server) releaseAll() {
+	s.mu.Lock()
+	holds := s.holds
+	s.holds = make(map[key]*hold)
+	s.mu.Unlock()
+
+	for k, h := range holds {
+		if h.expiry != nil {
+			h.expiry.Stop()
+		}
+		s.log.Log(logging.Info, "agent shutting down, releasing",
+			logging.F("path", k.path), logging.F("holder", k.holder))
+		s.releaseHold(k)
+	}
+}
@@ -0,0 +1,169 @@
+// Package backoff decides how long to wait between retries of an operation
+// on a semaphore, such as after losing a check-and-set race or failing to
+// reach Consul, and when to give up.
+package backoff
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Policy makes a Backoff for each operation to be retried.
+type Policy interface {
+	New() Backoff
+}
+
+// Backoff gives the delay before each retry of a single operation, or
+// false once the operation should no longer be retried.
+type Backoff interface {
+	Next() (delay time.Duration, ok bool)
+}
+
+// Default is the policy used unless another is given: up to a second,
+// chosen at random, between retries, for up to five minutes.
+var Default Policy = Limit{Policy: Constant{Delay: time.Second, Jitter: true}, MaxElapsed: 5 * time.Minute}
+
+var (
+	randMu sync.Mutex
+	random = rand.New(rand.NewSource(time.Now().UnixNano()))
+)
+
+// between returns a random duration in [min, max).
+func between(min, max time.Duration) time.Duration {
+	if max <= min {
+		return min
+	}
+
+	randMu.Lock()
+	defer randMu.Unlock()
+	return min + time.Duration(random.Int63n(int64(max-min)))
+}
+
+// Constant waits Delay between retries, or with Jitter, a random duration
+// up to Delay.
+type Constant struct {
+	Delay  time.Duration
+	Jitter bool
+}
+
+func (c Constant) New() Backoff {
+	return c
+}
+
+func (c Constant) Next() (time.Duration, bool) {
+	if c.Jitter {
+		return between(0, c.Delay), true
+	}
+	return c.Delay, true
+}
+
+// Jitter is how Exponential randomizes its delays.
+type Jitter int
+
+const (
+	// NoJitter doubles the delay after every retry.
+	NoJitter Jitter = iota
+
+	// FullJitter picks a random delay up to the doubled delay.
+	FullJitter
+
+	// DecorrelatedJitter picks a random delay between Base and three
+	// times the last delay.
+	DecorrelatedJitter
+)
+
+// Exponential waits Base before the first retry, growing the delay up to
+// Max after each one, randomized according to Jitter.
+type Exponential struct {
+	Base   time.Duration
+	Max    time.Duration
+	Jitter Jitter
+}
+
+func (e Exponential) New() Backoff {
+	return &exponential{Exponential: e}
+}
+
+type exponential struct {
+	Exponential
+	ceiling time.Duration
+	last    time.Duration
+}
+
+func (e *exponential) Next() (time.Duration, bool) {
+	capped := func(d time.Duration) time.Duration {
+		if e.Max > 0 && d > e.Max {
+			return e.Max
+		}
+		return d
+	}
+
+	if e.Jitter == DecorrelatedJitter {
+		if e.last == 0 {
+			e.last = e.Base
+		}
+		e.last = capped(between(e.Base, 3*e.last))
+		return e.last, true
+	}
+
+	if e.ceiling == 0 {
+		e.ceiling = e.Base
+	} else {
+		e.ceiling = capped(2 * e.ceiling)
+	}
+	if e.Jitter == FullJitter {
+		return between(0, e.ceiling), true
+	}
+	return e.ceiling, true
+}
+
+// Limit gives up on an operation after MaxAttempts retries, or once
+// MaxElapsed has passed since the first, whichever comes first.  Zero
+// means no limit.
+type Limit struct {
+	Policy      Policy
+	MaxAttempts int
+	MaxElapsed  time.Duration
+}
+
+// now is replaced by tests.
+var now = time.Now
+
+func (l Limit) New() Backoff {
+	return &limited{Limit: l, b: l.Policy.New()}
+}
+
+type limited struct {
+	Limit
+	b        Backoff
+	attempts int
+	start    time.Time
+}
+
+func (l *limited) Next() (time.Duration, bool) {
+	if l.start.IsZero() {
+		l.start = now()
+	}
+
+	l.attempts++
+	if l.MaxAttempts > 0 && l.attempts > l.MaxAttempts {
+		return 0, false
+	}
+
+	delay, ok := l.b.Next()
+	if !ok {
+		return 0, false
+	}
+
+	if l.MaxElapsed > 0 {
+		left := l.MaxElapsed - now().Sub(l.start)
+		if left <= 0 {
+			return 0, false
+		}
+		if delay > left {
+			delay = left
+		}
+	}
+	return delay, true
+}
@@ -0,0 +1,72 @@
+package backoff
+
+import (
+	"testing"
+	"time"
+)
+
+func TestConstant(t *testing.T) {
+	b := Constant{Delay: time.Second}.New()
+	for i := 0; i < 3; i++ {
+		if d, ok := b.Next(); d != time.Second || !ok {
+			t.Errorf("retry %d: got %v, %v", i, d, ok)
+		}
+	}
+
+	b = Constant{Delay: time.Second, Jitter: true}.New()
+	for i := 0; i < 100; i++ {
+		if d, _ := b.Next(); d < 0 || d >= time.Second {
+			t.Errorf("jittered delay %v out of range", d)
+		}
+	}
+}
+
+func TestExponential(t *testing.T) {
+	b := Exponential{Base: time.Second, Max: 5 * time.Second}.New()
+	want := []time.Duration{1, 2, 4, 5, 5}
+	for i, w := range want {
+		if d, ok := b.Next(); d != w*time.Second || !ok {
+			t.Errorf("retry %d: got %v, %v, want %v", i, d, ok, w*time.Second)
+		}
+	}
+
+	b = Exponential{Base: time.Second, Max: 5 * time.Second, Jitter: FullJitter}.New()
+	for i, w := range want {
+		if d, _ := b.Next(); d < 0 || d >= w*time.Second {
+			t.Errorf("retry %d: full jitter delay %v not under %v", i, d, w*time.Second)
+		}
+	}
+
+	b = Exponential{Base: time.Second, Max: 5 * time.Second, Jitter: DecorrelatedJitter}.New()
+	for i := 0; i < 100; i++ {
+		if d, _ := b.Next(); d < time.Second || d > 5*time.Second {
+			t.Errorf("retry %d: decorrelated delay %v out of range", i, d)
+		}
+	}
+}
+
+func TestLimit(t *testing.T) {
+	b := Limit{Policy: Constant{Delay: time.Second}, MaxAttempts: 2}.New()
+	for i, want := range []bool{true, true, false} {
+		if _, ok := b.Next(); ok != want {
+			t.Errorf("retry %d: got %v, want %v", i, ok, want)
+		}
+	}
+
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	b = Limit{Policy: Constant{Delay: 4 * time.Second}, MaxElapsed: 10 * time.Second}.New()
+	steps := []struct {
+		delay time.Duration
+		ok    bool
+	}{{4 * time.Second, true}, {4 * time.Second, true}, {2 * time.Second, true}, {0, false}}
+	for i, want := range steps {
+		d, ok := b.Next()
+		if d != want.delay || ok != want.ok {
+			t.Errorf("retry %d: got %v, %v, want %v, %v", i, d, ok, want.delay, want.ok)
+		}
+		clock = clock.Add(d)
+	}
+}
@@ -4,9 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/cli"
-	"github.com/ryanschneider/consul-semaphore/semaphore"
 )
 
 type AcquireCommand struct {
@@ -16,23 +16,54 @@ type AcquireCommand struct {
 
 func (c *AcquireCommand) Run(args []string) int {
 	var wait bool
+	var waitTimeout time.Duration
+	var gates gateFlags
+	var ttl time.Duration
 	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
 		f.BoolVar(&wait, "wait", false, "wait for semaphore if blocked")
+		f.DurationVar(&waitTimeout, "wait-timeout", 0, "how long to wait for semaphore")
+		f.DurationVar(&ttl, "ttl", 0, "with the agent, release unless renewed within this long")
+		gates.addFlags(f)
 	})
 	if err != nil {
 		return 1
 	}
 
-	sem, err := semaphore.New(parser.Path, parser.Holder)
+	// gates are checked here, so only go through the agent without them
+	if ac := parser.agent(); ac != nil && gates.service == "" {
+		req := parser.agentRequest()
+		req.Wait, req.WaitTimeout, req.TTL = wait, waitTimeout, ttl
+		if _, err := ac.Acquire(req); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error acquiring semaphore: %s", err))
+			return exitCode(err, ExitError)
+		}
+		return 0
+	}
+	if ttl > 0 {
+		c.Ui.Error("Error: -ttl needs a running agent, see \"agent\"")
+		return 1
+	}
+
+	sem, client, err := parser.semaphore()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	err = gates.apply(sem, client)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error: %s", err))
 		return 1
 	}
 
-	err = sem.Acquire(wait)
+	if waitTimeout > 0 {
+		err = sem.AcquireTimeout(waitTimeout)
+	} else {
+		err = sem.Acquire(wait)
+	}
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error acquiring semaphore: %s", err))
-		return 1
+		return exitCode(err, ExitError)
 	}
 
 	return 0
@@ -46,13 +77,24 @@ func (c *AcquireCommand) Help() string {
 	helpText := `
 Usage consul-semaphore acquire [options]
 
-  Acquires a semaphore in Consul.
+  Acquires a semaphore in Consul.  If an agent is running (see "agent"),
+  the semaphore is acquired through it, and it holds the semaphore until
+  it is released, unless -require-service is given.
+
+  Failures use these exit codes:
+
+%s
 
 Options:
 
 	-wait                      Wait for semaphore, if blocked
+	-wait-timeout              Wait at most this long for semaphore, e.g. 15m,
+	                           then exit with code 201; implies -wait
+	-ttl                       Through the agent, release the semaphore
+	                           unless renewed within this long (see "renew")
+%s
 %s
 	`
 
-	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+	return strings.TrimSpace(fmt.Sprintf(helpText, exitHelp(), gateHelp(), commonHelp()))
 }
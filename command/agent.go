@@ -0,0 +1,93 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/agent"
+)
+
+// AgentCommand runs the agent, which holds semaphores for other commands.
+type AgentCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *AgentCommand) Run(args []string) int {
+	var metricsAddr string
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&metricsAddr, "metrics-addr", "", "address to serve metrics on")
+	})
+	if err != nil {
+		return 1
+	}
+
+	if parser.Agent == "" {
+		c.Ui.Error("Error: -agent must name the socket to listen on")
+		return 1
+	}
+
+	if metricsAddr != "" {
+		if err := serveMetrics(metricsAddr); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	c.Ui.Output(fmt.Sprintf("Agent listening on %s", parser.Agent))
+	sig, err := interruptible(signals, func(ctx context.Context) error {
+		return agent.NewServer(parser.Agent, parser.Backoff).Serve(ctx)
+	})
+	if sig != nil {
+		c.Ui.Output(fmt.Sprintf("Received %v, released all holds", sig))
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error running agent: %s", err))
+		return 1
+	}
+
+	return 0
+}
+
+func (c *AgentCommand) Synopsis() string {
+	return "Runs an agent that holds semaphores for other commands"
+}
+
+func (c *AgentCommand) Help() string {
+	helpText := `
+Usage consul-semaphore agent [options]
+
+  Runs an agent, listening on a Unix socket, that keeps its Consul
+  connections and sessions open between commands.  While it runs,
+  "acquire", "release", "renew" and "status" go through it, and semaphores
+  acquired through it stay held after "acquire" exits, until released or,
+  if acquired with -ttl, until they go unrenewed for that long.
+
+  The socket is given by -agent, which defaults to $%s, or a socket in
+  $XDG_RUNTIME_DIR, or failing that, in a per-user directory in the
+  temporary directory.  The socket's directory must belong to the user and
+  be writable by no one else, and commands only use a socket that belongs
+  to the user, so other users can't stand in for the agent.
+
+  When the agent is interrupted it releases every semaphore it holds.
+  Each hold is tied to a Consul session that the agent renews every 15s.
+  If the agent dies without releasing its holds, the session expires after
+  30s, and the next acquire that finds the semaphore exhausted evicts them.
+
+Options:
+
+%s
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, agent.SocketEnv, metricsHelp(), commonHelp()))
+}
@@ -0,0 +1,80 @@
+package command
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+// condition is a test of a semaphore's state, given on the command line.
+type condition func(*lock.Semaphore) bool
+
+// parseCondition parses one of:
+//
+//	empty            no holders
+//	available=N      at least N available
+//	holders<=N       at most N holders
+//	holder-absent=H  H does not hold the semaphore
+func parseCondition(s string) (condition, error) {
+	switch {
+	case s == "empty":
+		return func(sem *lock.Semaphore) bool {
+			return len(sem.Holders) == 0
+		}, nil
+
+	case strings.HasPrefix(s, "available="):
+		n, err := conditionCount(s, "available=")
+		if err != nil {
+			return nil, err
+		}
+		return func(sem *lock.Semaphore) bool {
+			return sem.Semaphore >= n
+		}, nil
+
+	case strings.HasPrefix(s, "holders<="):
+		n, err := conditionCount(s, "holders<=")
+		if err != nil {
+			return nil, err
+		}
+		return func(sem *lock.Semaphore) bool {
+			return len(sem.Holders) <= n
+		}, nil
+
+	case strings.HasPrefix(s, "holder-absent="):
+		holder := strings.TrimPrefix(s, "holder-absent=")
+		if holder == "" {
+			return nil, fmt.Errorf("no holder given in condition %q", s)
+		}
+		return func(sem *lock.Semaphore) bool {
+			for _, h := range sem.Holders {
+				if h == holder {
+					return false
+				}
+			}
+			return true
+		}, nil
+	}
+
+	return nil, fmt.Errorf("unknown condition %q", s)
+}
+
+func conditionCount(s, prefix string) (int, error) {
+	n, err := strconv.Atoi(strings.TrimPrefix(s, prefix))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("condition %q needs a count of 0 or more", s)
+	}
+	return n, nil
+}
+
+func conditionHelp() string {
+	helpText := `
+	                           empty: no holders
+	                           available=N: at least N available
+	                           holders<=N: at most N holders
+	                           holder-absent=H: H does not hold it
+`
+
+	return helpText[1 : len(helpText)-1]
+}
@@ -0,0 +1,46 @@
+package command
+
+import (
+	"testing"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+func TestParseCondition(t *testing.T) {
+	idle := &lock.Semaphore{Max: 3, Semaphore: 3}
+	busy := &lock.Semaphore{Max: 3, Semaphore: 1, Holders: []string{"a", "b"}}
+
+	tests := []struct {
+		condition  string
+		idle, busy bool
+	}{
+		{"empty", true, false},
+		{"available=1", true, true},
+		{"available=2", true, false},
+		{"holders<=2", true, true},
+		{"holders<=1", true, false},
+		{"holders<=0", true, false},
+		{"holder-absent=a", true, false},
+		{"holder-absent=c", true, true},
+	}
+
+	for _, test := range tests {
+		cond, err := parseCondition(test.condition)
+		if err != nil {
+			t.Errorf("%s: %v", test.condition, err)
+			continue
+		}
+		if got := cond(idle); got != test.idle {
+			t.Errorf("%s on an idle semaphore: got %v, want %v", test.condition, got, test.idle)
+		}
+		if got := cond(busy); got != test.busy {
+			t.Errorf("%s on a busy semaphore: got %v, want %v", test.condition, got, test.busy)
+		}
+	}
+
+	for _, bad := range []string{"", "full", "available=", "available=-1", "holders<=x", "holders<3", "holder-absent="} {
+		if _, err := parseCondition(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
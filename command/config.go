@@ -0,0 +1,194 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// configEnv and profileEnv give -config and -profile defaults.
+	configEnv  = "CONSUL_SEMAPHORE_CONFIG"
+	profileEnv = "CONSUL_SEMAPHORE_PROFILE"
+
+	// defaultConfig is read, if it exists, when no config file is given.
+	defaultConfig = "/etc/consul-semaphore.json"
+
+	// envPrefix starts the environment variable for each flag, e.g.
+	// CONSUL_SEMAPHORE_CONSUL for -consul; see envName.
+	envPrefix = "CONSUL_SEMAPHORE_"
+)
+
+// config is a config file: default values for the flags common to every
+// command, keyed by flag name; sections of values for one command's own
+// flags, keyed by the command's name; and named profiles of further values,
+// laid out the same way, under "semaphore":
+//
+//	{
+//	  "consul": "consul.service:8500",
+//	  "acquire": {"wait-timeout": "15m"},
+//	  "semaphore": {
+//	    "db-restart": {"path": "locks/db-restart", "set-max": {"max": 1}}
+//	  }
+//	}
+//
+// Scoping values by command keeps flags that share a name but not a
+// meaning, such as exec's and reap's -grace, apart.
+type config struct {
+	settings map[string]interface{}
+	profiles map[string]map[string]interface{}
+}
+
+// loadConfig reads the config file at path.  Without a path, the default
+// config file is read if there is one.
+func loadConfig(path string) (*config, error) {
+	explicit := path != ""
+	if !explicit {
+		path = defaultConfig
+	}
+
+	b, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) && !explicit {
+		return &config{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var raw map[string]interface{}
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&raw); err != nil {
+		return nil, fmt.Errorf("reading config %s: %s", path, err)
+	}
+
+	c := &config{settings: raw, profiles: make(map[string]map[string]interface{})}
+	if profiles, ok := raw["semaphore"]; ok {
+		delete(raw, "semaphore")
+		m, ok := profiles.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("reading config %s: \"semaphore\" must hold profiles by name", path)
+		}
+		for name, p := range m {
+			if c.profiles[name], ok = p.(map[string]interface{}); !ok {
+				return nil, fmt.Errorf("reading config %s: profile %q must be an object", path, name)
+			}
+		}
+	}
+
+	return c, nil
+}
+
+// apply sets each flag of the named command not given on the command line
+// from, in increasing order of precedence: the config file, its section for
+// the command, the profile, the profile's section for the command, and the
+// environment.  common has the flags every command shares.
+func (c *config) apply(f *flag.FlagSet, name string, common, given map[string]bool, profile string) error {
+	layers := []map[string]interface{}{c.settings}
+	where := []string{"the config file"}
+	if profile != "" {
+		p, ok := c.profiles[profile]
+		if !ok {
+			return fmt.Errorf("no profile %q in the config file", profile)
+		}
+		layers = append(layers, p)
+		where = append(where, fmt.Sprintf("profile %q", profile))
+	}
+	for i, layer := range layers {
+		if err := check(layer, f, name, common); err != nil {
+			return fmt.Errorf("in %s: %s", where[i], err)
+		}
+	}
+
+	var err error
+	f.VisitAll(func(fl *flag.Flag) {
+		if err != nil || given[fl.Name] || fl.Name == "config" || fl.Name == "profile" {
+			return
+		}
+
+		var value interface{}
+		for _, layer := range layers {
+			if x, ok := layer[fl.Name]; ok && common[fl.Name] {
+				value = x
+			}
+			if section, ok := layer[name].(map[string]interface{}); ok {
+				if x, ok := section[fl.Name]; ok {
+					value = x
+				}
+			}
+		}
+		if x, ok := os.LookupEnv(envName(name, fl.Name, common)); ok {
+			value = x
+		}
+		if value == nil {
+			return
+		}
+
+		// repeatable flags take a list
+		list, ok := value.([]interface{})
+		if !ok {
+			list = []interface{}{value}
+		}
+		for _, v := range list {
+			if serr := f.Set(fl.Name, fmt.Sprint(v)); serr != nil {
+				err = fmt.Errorf("setting -%s from config or environment: %s", fl.Name, serr)
+				return
+			}
+		}
+	})
+
+	return err
+}
+
+// check fails for values in layer that no flag takes: top-level values
+// other than common flags, and values in the named command's section that
+// it has no flag for.  Other commands' sections are left to them.
+func check(layer map[string]interface{}, f *flag.FlagSet, name string, common map[string]bool) error {
+	for k, v := range layer {
+		section, ok := v.(map[string]interface{})
+		switch {
+		case !ok && !common[k]:
+			return fmt.Errorf("%q is not a flag every command has; set it in a command's section, such as {\"exec\": {%q: ...}}", k, k)
+		case ok && k == name:
+			for flagName := range section {
+				if f.Lookup(flagName) == nil {
+					return fmt.Errorf("%s has no -%s flag", name, flagName)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// envName is the environment variable setting the named command's flag:
+// CONSUL_SEMAPHORE_<FLAG> for a common flag, or
+// CONSUL_SEMAPHORE_<COMMAND>_<FLAG> for one of the command's own, e.g.
+// CONSUL_SEMAPHORE_EXEC_GRACE.
+func envName(command, flagName string, common map[string]bool) string {
+	name := flagName
+	if !common[flagName] {
+		name = command + "_" + flagName
+	}
+	return envPrefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+}
+
+func configHelp() string {
+	helpText := `
+	-config                    JSON file of default flag values and profiles,
+	                           default $%s or %s.
+	                           Common flags go at the top level, a
+	                           command's own in a section named after it,
+	                           e.g. {"consul": "...", "exec": {"grace": "30s"}}.
+	                           The environment can set flags too, e.g.
+	                           %sCONSUL or %sEXEC_GRACE
+	-profile                   Use the flag values of this profile from the
+	                           config file, default $%s
+`
+
+	helpText = fmt.Sprintf(helpText, configEnv, defaultConfig, envPrefix, envPrefix, profileEnv)
+	return helpText[1 : len(helpText)-1]
+}
@@ -0,0 +1,182 @@
+package command
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeConfig writes a config file for the test, returning its path.
+func writeConfig(t *testing.T, body string) string {
+	dir, err := os.MkdirTemp("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const testConfig = `{
+  "consul": "file:8500",
+  "path": "file/path",
+  "holder": "file-holder",
+  "test": {"path": "section/path", "health-check": ["web", "db"], "max": 1},
+  "semaphore": {
+    "db": {"holder": "profile-holder", "test": {"max": 2}}
+  }
+}`
+
+func TestConfigPrecedence(t *testing.T) {
+	config := writeConfig(t, testConfig)
+	os.Setenv("CONSUL_SEMAPHORE_HOLDER", "env-holder")
+	defer os.Unsetenv("CONSUL_SEMAPHORE_HOLDER")
+
+	var max uint
+	p, err := newParser("test", []string{"-config", config, "-profile", "db", "-consul", "flag:8500"}, func(f *flag.FlagSet) {
+		f.UintVar(&max, "max", 1, "")
+		f.Var(new(stringList), "health-check", "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := []string{p.Consul, p.Path, p.Holder}
+	want := []string{"flag:8500", "section/path", "env-holder"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got consul, path, holder %q, want %q", got, want)
+	}
+	if max != 2 {
+		t.Errorf("got max %d from the profile, want 2", max)
+	}
+
+	// set-max requires -max, which the profile gives
+	if !p.isSet("max") {
+		t.Error("isSet(max) is false for a value from the profile")
+	}
+	if p.given["max"] || p.given["holder"] {
+		t.Error("values from the config file and environment count as given")
+	}
+}
+
+func TestConfigFlagOverEnv(t *testing.T) {
+	config := writeConfig(t, testConfig)
+	os.Setenv("CONSUL_SEMAPHORE_PATH", "env/path")
+	defer os.Unsetenv("CONSUL_SEMAPHORE_PATH")
+
+	p, err := newParser("other", []string{"-config", config}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path != "env/path" || p.Holder != "file-holder" {
+		t.Errorf("got path %q, holder %q, want env/path, file-holder", p.Path, p.Holder)
+	}
+
+	p, err = newParser("other", []string{"-config", config, "-path", "flag/path"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Path != "flag/path" {
+		t.Errorf("got path %q, want flag/path", p.Path)
+	}
+}
+
+func TestConfigList(t *testing.T) {
+	config := writeConfig(t, testConfig)
+
+	var checks stringList
+	_, err := newParser("test", []string{"-config", config}, func(f *flag.FlagSet) {
+		f.Var(&checks, "health-check", "")
+		f.Uint("max", 1, "")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (stringList{"web", "db"}); !reflect.DeepEqual(checks, want) {
+		t.Errorf("got health checks %q, want %q", checks, want)
+	}
+}
+
+// TestConfigCommands applies one config to commands whose flags share a
+// name but not a meaning, declared as the commands declare them.
+func TestConfigCommands(t *testing.T) {
+	config := writeConfig(t, `{
+  "consul": "file:8500",
+  "exec": {"prefix-output": true, "grace": "30s"},
+  "reap": {"prefix": "semaphores/", "grace": "10m"},
+  "list": {"prefix": "semaphores/"}
+}`)
+	os.Setenv("CONSUL_SEMAPHORE_REAP_GRACE", "20m")
+	defer os.Unsetenv("CONSUL_SEMAPHORE_REAP_GRACE")
+
+	var (
+		prefixOutput           bool
+		execGrace, reapGrace   time.Duration
+		reapPrefix, listPrefix string
+	)
+	_, err := newParser("exec", []string{"-config", config}, func(f *flag.FlagSet) {
+		f.BoolVar(&prefixOutput, "prefix-output", false, "")
+		f.DurationVar(&execGrace, "grace", 10*time.Second, "")
+	})
+	if err != nil {
+		t.Fatalf("exec: %s", err)
+	}
+	_, err = newParser("reap", []string{"-config", config}, func(f *flag.FlagSet) {
+		f.StringVar(&reapPrefix, "prefix", "", "")
+		f.DurationVar(&reapGrace, "grace", 5*time.Minute, "")
+	})
+	if err != nil {
+		t.Fatalf("reap: %s", err)
+	}
+	_, err = newParser("list", []string{"-config", config}, func(f *flag.FlagSet) {
+		f.StringVar(&listPrefix, "prefix", "", "")
+	})
+	if err != nil {
+		t.Fatalf("list: %s", err)
+	}
+
+	if !prefixOutput || execGrace != 30*time.Second {
+		t.Errorf("exec got -prefix-output %v, -grace %v", prefixOutput, execGrace)
+	}
+	if reapPrefix != "semaphores/" || reapGrace != 20*time.Minute {
+		t.Errorf("reap got -prefix %q, -grace %v", reapPrefix, reapGrace)
+	}
+	if listPrefix != "semaphores/" {
+		t.Errorf("list got -prefix %q", listPrefix)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	tests := []struct {
+		body    string
+		profile string
+		err     string
+	}{
+		{testConfig, "missing", `no profile "missing"`},
+		{`{"semaphore": "db"}`, "", `"semaphore" must hold profiles`},
+		{`{"semaphore": {"db": 1}}`, "", `profile "db" must be an object`},
+		{`{"path": `, "", "reading config"},
+		{`{"grace": "30s"}`, "", `"grace" is not a flag every command has`},
+		{`{"test": {"grace": "30s"}}`, "", "test has no -grace flag"},
+		{`{"semaphore": {"db": {"max": 2}}}`, "db", `in profile "db": "max" is not a flag`},
+	}
+
+	for _, test := range tests {
+		args := []string{"-config", writeConfig(t, test.body)}
+		if test.profile != "" {
+			args = append(args, "-profile", test.profile)
+		}
+		_, err := newParser("test", args, nil)
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: got error %v, want %q", test.body, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package command
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+// EnvCommand prints the environment exec gives its command.
+type EnvCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *EnvCommand) Run(args []string) int {
+	parser, err := newParser(c.Name, args, nil)
+	if err != nil {
+		return 1
+	}
+
+	sem, _, err := parser.semaphore()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	state, err := sem.Get()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	for _, kv := range semaphoreEnv(sem.Path, sem.Holder, state) {
+		i := strings.Index(kv, "=")
+		c.Ui.Output(fmt.Sprintf("export %s=%s", kv[:i], shellQuote(kv[i+1:])))
+	}
+
+	return 0
+}
+
+// semaphoreEnv describes the semaphore at path, as seen by holder, in
+// environment variables.  Variables describing the hold are only set if
+// holder holds the semaphore.
+func semaphoreEnv(path, holder string, state *lock.Semaphore) []string {
+	env := map[string]string{
+		"SEMAPHORE_PATH":    path,
+		"SEMAPHORE_HOLDER":  holder,
+		"SEMAPHORE_MAX":     fmt.Sprint(state.Max),
+		"SEMAPHORE_HOLDERS": fmt.Sprint(len(state.Holders)),
+	}
+
+	if info, ok := state.HolderInfo[holder]; ok {
+		env["SEMAPHORE_ACQUIRED"] = info.Started().UTC().Format(time.RFC3339)
+		if info.Slot != nil {
+			env["SEMAPHORE_SLOT"] = fmt.Sprint(*info.Slot)
+		}
+	}
+
+	vars := make([]string, 0, len(env))
+	for k, v := range env {
+		vars = append(vars, k+"="+v)
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+func (c *EnvCommand) Synopsis() string {
+	return "Prints the environment exec gives its command"
+}
+
+func (c *EnvCommand) Help() string {
+	helpText := `
+Usage consul-semaphore env [options]
+
+  Prints the environment exec gives its command, in shell export format,
+  for example:
+
+    eval "$(consul-semaphore env -path global/restarts)"
+
+  SEMAPHORE_PATH                 The semaphore's KV path
+  SEMAPHORE_HOLDER               The holder's name
+  SEMAPHORE_MAX                  The maximum number of holders
+  SEMAPHORE_HOLDERS              The current number of holders
+  SEMAPHORE_ACQUIRED             When the holder acquired the semaphore, in
+                                 RFC 3339 format; only set while it is held
+  SEMAPHORE_SLOT                 The holder's slot, in [0, max); only set
+                                 while it is held, if slots are numbered
+
+Options:
+
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
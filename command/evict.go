@@ -0,0 +1,126 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// EvictCommand removes another holder from a semaphore.
+type EvictCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *EvictCommand) Run(args []string) int {
+	var (
+		reason string
+		by     string
+		yes    bool
+	)
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&reason, "reason", "", "why the holder is evicted")
+		f.StringVar(&by, "by", "", "who is evicting the holder (default user@hostname)")
+		f.BoolVar(&yes, "yes", false, "do not ask for confirmation")
+	})
+	if err != nil {
+		return 1
+	}
+
+	// the default holder, which the config file or environment may give,
+	// is usually ourselves, so the victim must be named explicitly
+	switch {
+	case !parser.given["holder"]:
+		c.Ui.Error("Error: -holder is required on the command line")
+		return 1
+	case reason == "":
+		c.Ui.Error("Error: -reason is required")
+		return 1
+	}
+
+	if by == "" {
+		by = defaultActor()
+	}
+
+	client, err := parser.consulClient()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	// check the semaphore exists before asking, and don't create it
+	if _, err := semaphore.Inspect(client, parser.Path, true); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore %s: %s", parser.Path, err))
+		return exitCode(err, ExitError)
+	}
+	sem, err := semaphore.Open(parser.Path, by, client)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+	sem.SetBackoff(parser.Backoff)
+
+	if !yes {
+		answer, err := c.Ui.Ask(fmt.Sprintf("Evict %s from %s? [y/N]", parser.Holder, parser.Path))
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error reading confirmation: %s", err))
+			return 1
+		}
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			c.Ui.Output("Not evicted")
+			return 1
+		}
+	}
+
+	err = sem.Evict(parser.Holder, reason)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error evicting %s: %s", parser.Holder, err))
+		return exitCode(err, ExitError)
+	}
+
+	c.Ui.Output(fmt.Sprintf("Evicted %s from %s", parser.Holder, parser.Path))
+	return 0
+}
+
+// defaultActor names the person running the command, as user@hostname.
+func defaultActor() string {
+	name := "unknown"
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		return name
+	}
+	return name + "@" + hostname
+}
+
+func (c *EvictCommand) Synopsis() string {
+	return "Removes another holder from a semaphore"
+}
+
+func (c *EvictCommand) Help() string {
+	helpText := `
+Usage consul-semaphore evict -holder <holder> -reason <reason> [options]
+
+  Removes a holder from a semaphore, such as one whose process died
+  without releasing it.  The eviction, who made it, and why are recorded
+  in the semaphore's audit trail.  An "exec" still running for the holder
+  notices, terminates its command, and exits with code 206.
+
+Options:
+
+	-reason                    Why the holder is evicted, required
+	-by                        Who is evicting the holder, for the audit
+	                           trail, default user@hostname
+	-yes                       Do not ask for confirmation
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
@@ -1,13 +1,19 @@
 package command
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"time"
 
+	api "github.com/armon/consul-api"
 	"github.com/mitchellh/cli"
-	"github.com/ryanschneider/consul-semaphore/semaphore"
+	"github.com/ryanschneider/consul-semaphore/health"
+	"github.com/ryanschneider/consul-semaphore/lock"
 )
 
 // ExecCommand handles cthe "exec" action
@@ -16,8 +22,41 @@ type ExecCommand struct {
 	Name string
 }
 
+// Policies for when the health checks don't pass after the command.
+const (
+	healthHold    = "hold"
+	healthRelease = "release"
+	healthFreeze  = "freeze"
+)
+
 func (c *ExecCommand) Run(args []string) (ret int) {
+	var (
+		healthChecks  stringList
+		healthService string
+		healthTimeout time.Duration
+		healthSettle  time.Duration
+		healthFailure string
+		gates         gateFlags
+		prefix        bool
+		limits        execLimits
+		timeoutFreeze bool
+		waitTimeout   time.Duration
+		metricsAddr   string
+	)
 	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.Var(&healthChecks, "health-check", "check to wait for before releasing")
+		f.StringVar(&healthService, "health-service", "", "service whose checks to wait for")
+		f.DurationVar(&healthTimeout, "health-timeout", 5*time.Minute, "how long to wait for checks")
+		f.DurationVar(&healthSettle, "health-settle", 10*time.Second, "how long to wait before looking at checks")
+		f.StringVar(&healthFailure, "health-failure", healthHold, "hold, release or freeze")
+		gates.addFlags(f)
+		f.DurationVar(&waitTimeout, "wait-timeout", 0, "how long to wait for semaphore")
+		f.DurationVar(&limits.grace, "grace", 10*time.Second, "time for the command to exit once signalled")
+		f.DurationVar(&limits.timeout, "timeout", 0, "time the command may run")
+		f.DurationVar(&limits.killAfter, "kill-after", 10*time.Second, "time for the command to exit once timed out")
+		f.BoolVar(&timeoutFreeze, "timeout-freeze", false, "freeze the semaphore if the command times out")
+		f.BoolVar(&prefix, "prefix-output", false, "prefix output lines with time and holder")
+		f.StringVar(&metricsAddr, "metrics-addr", "", "address to serve metrics on")
 	})
 	if err != nil {
 		return 1
@@ -31,50 +70,290 @@ func (c *ExecCommand) Run(args []string) (ret int) {
 		return 1
 	}
 
-	sem, err := semaphore.New(parser.Path, parser.Holder)
+	switch healthFailure {
+	case healthHold, healthRelease, healthFreeze:
+	default:
+		c.Ui.Error(fmt.Sprintf("Unknown -health-failure policy: %s", healthFailure))
+		return 1
+	}
+	gate := health.Selector{Checks: healthChecks, Service: healthService}
+	if !gate.Empty() && (healthSettle < 0 || healthSettle >= healthTimeout) {
+		c.Ui.Error("Error: -health-settle must be shorter than -health-timeout")
+		return 1
+	}
+
+	if metricsAddr != "" {
+		if err := serveMetrics(metricsAddr); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+	}
+
+	sem, client, err := parser.semaphore()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	err = gates.apply(sem, client)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error: %s", err))
 		return 1
 	}
 
-	err = sem.Acquire(true)
+	// Catch signals from here on, so we always get to release the
+	// semaphore.  They are forwarded to the command while it runs.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	sig, err := interruptible(signals, func(ctx context.Context) error {
+		if waitTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+			defer cancel()
+		}
+		return sem.AcquireContext(ctx, true)
+	})
+	if sig != nil {
+		c.Ui.Error(fmt.Sprintf("Received %v while waiting for semaphore, giving up", sig))
+		if err == nil {
+			// acquired just as we were interrupted
+			if err = sem.Release(); err != nil {
+				c.Ui.Error(fmt.Sprintf("Error releasing semaphore: %s", err))
+			}
+		}
+		return signalExitCode(sig)
+	}
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error acquiring semaphore: %s", err))
-		return 1
+		return exitCode(err, ExitError)
 	}
 
 	//defer Release until after the command is executed
+	release := true
 	defer func() {
+		if !release {
+			return
+		}
 		err = sem.Release()
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error releasing semaphore: %s", err))
-			ret = 1
+			// the command's own failure is more useful to the caller
+			if ret == 0 {
+				ret = ExitReleaseFailed
+			}
 		}
 	}()
 
-	//execute the command
-	err = c.execute(remainingArgs...)
+	//execute the command, passing on its exit status
+	cmd := exec.Command(remainingArgs[0], remainingArgs[1:]...)
+	cmd.Env = os.Environ()
+	if state, err := sem.Get(); err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore for environment: %s", err))
+	} else {
+		cmd.Env = append(cmd.Env, semaphoreEnv(sem.Path, sem.Holder, state)...)
+	}
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if prefix {
+		cmd.Stdout = &prefixWriter{w: os.Stdout, prefix: holderPrefix(sem.Holder)}
+		cmd.Stderr = &prefixWriter{w: os.Stderr, prefix: holderPrefix(sem.Holder)}
+	}
+
+	// Watch for the holder being evicted while the command runs.
+	evictCtx, stopEvictions := context.WithCancel(context.Background())
+	defer stopEvictions()
+	evictions, err := sem.Evicted(evictCtx)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error watching for eviction: %s", err))
+	}
+
+	ended, sig, err := c.execute(cmd, signals, evictions, limits)
+	stopEvictions()
+	switch ended {
+	case endEvicted:
+		// the semaphore is no longer ours to release
+		release = false
+		return ExitEvicted
+	case endTimedOut:
+		if timeoutFreeze {
+			reason := fmt.Sprintf("%s: command timed out after %v", sem.Holder, limits.timeout)
+			if ferr := sem.Freeze(reason); ferr != nil {
+				c.Ui.Error(fmt.Sprintf("Error freezing semaphore: %s", ferr))
+			}
+		}
+		return ExitCommandTimeout
+	}
+	if sig != nil {
+		// we are being stopped, so release rather than wait for health
+		return signalExitCode(sig)
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return exitStatus(exitErr.ProcessState)
+	}
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error executing command: %s", err))
-		return 1
+		return ExitCannotRun
 	}
 
-	return 0
-}
+	if gate.Empty() {
+		return 0
+	}
 
-// execute accepts a args list and runs a command
-func (c *ExecCommand) execute(args ...string) error {
-	// Create and invoke the command
-	cmd := exec.Command(args[0], args[1:]...)
-	cmd.Stdout = WriterFunc(func(p []byte) (n int, err error) {
-		c.Ui.Output(fmt.Sprintf("%s", p))
-		return len(p), nil
-	})
-	cmd.Stderr = WriterFunc(func(p []byte) (n int, err error) {
-		c.Ui.Error(fmt.Sprintf("%s", p))
-		return len(p), nil
+	//hold on to the semaphore until the local service is healthy again
+	sig, err = interruptible(signals, func(ctx context.Context) error {
+		return c.waitHealthy(ctx, client, gate, healthSettle, healthTimeout)
 	})
-	return cmd.Run()
+	if sig != nil {
+		c.Ui.Error(fmt.Sprintf("Received %v while waiting for health checks, releasing", sig))
+		return signalExitCode(sig)
+	}
+	if err == nil {
+		return 0
+	}
+
+	c.Ui.Error(fmt.Sprintf("Error waiting for health checks: %s", err))
+	switch healthFailure {
+	case healthHold:
+		c.Ui.Error(fmt.Sprintf("Keeping semaphore %s held by %s", sem.Path, sem.Holder))
+		release = false
+	case healthFreeze:
+		reason := fmt.Sprintf("%s: health checks failed after exec: %s", sem.Holder, err)
+		if ferr := sem.Freeze(reason); ferr != nil {
+			c.Ui.Error(fmt.Sprintf("Error freezing semaphore: %s", ferr))
+		}
+	}
+	return ExitUnhealthy
+}
+
+// waitHealthy waits for the selected checks on the local node to pass.
+func (c *ExecCommand) waitHealthy(ctx context.Context, client *api.Client, gate health.Selector, settle, timeout time.Duration) error {
+	node, err := health.LocalNode(client)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return health.WaitPassing(ctx, client, node, gate, settle)
+}
+
+// interruptible runs f with a context that is cancelled by the first of
+// signals to arrive, and waits for f to finish.  The signal, if any, is
+// returned along with f's error.
+func interruptible(signals <-chan os.Signal, f func(context.Context) error) (os.Signal, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- f(ctx)
+	}()
+
+	select {
+	case err := <-done:
+		return nil, err
+	case sig := <-signals:
+		cancel()
+		return sig, <-done
+	}
+}
+
+// execLimits bound how long exec's command may run.
+type execLimits struct {
+	// grace is how long the command has to exit once signalled.
+	grace time.Duration
+
+	// timeout, if set, is how long the command may run before it is
+	// terminated, and killAfter how long it then has to exit.
+	timeout   time.Duration
+	killAfter time.Duration
+}
+
+// How execute's command came to end.
+type ending int
+
+const (
+	endExited ending = iota
+	endTimedOut
+	endEvicted
+)
+
+// execute runs cmd.  Signals received while it runs are forwarded to the
+// command's process group; if the command has not exited grace after the
+// first one, it is killed, and the first signal is returned.  Likewise, a
+// command running past its timeout, or whose holder is evicted, is
+// terminated, then killed.
+//
+// When stdin is a terminal the command stays in our process group, as it
+// could not read from the terminal otherwise.  Signals the terminal sends
+// reach it directly then, and are not forwarded a second time.
+func (c *ExecCommand) execute(cmd *exec.Cmd, signals <-chan os.Signal, evictions <-chan lock.AuditEntry, limits execLimits) (ended ending, sig os.Signal, err error) {
+	tty := isTerminal(os.Stdin)
+	if !tty {
+		setProcessGroup(cmd)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return endExited, nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var deadline, kill <-chan time.Time
+	if limits.timeout > 0 {
+		deadline = time.After(limits.timeout)
+	}
+
+	send := func(sig os.Signal) {
+		if err := signalProcessGroup(cmd, sig); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error signalling command: %s", err))
+		}
+	}
+
+	for {
+		select {
+		case err := <-done:
+			return ended, sig, err
+		case s := <-signals:
+			if tty && terminalSignals[s] {
+				c.Ui.Error(fmt.Sprintf("Received %v, waiting for command to exit", s))
+			} else {
+				c.Ui.Error(fmt.Sprintf("Received %v, forwarding to command", s))
+				send(s)
+			}
+			if sig == nil {
+				sig = s
+			}
+			if kill == nil {
+				kill = time.After(limits.grace)
+			}
+		case <-deadline:
+			c.Ui.Error(fmt.Sprintf("Command timed out after %v, terminating it", limits.timeout))
+			ended = endTimedOut
+			send(terminateSignal)
+			kill = time.After(limits.killAfter)
+		case e, ok := <-evictions:
+			if !ok {
+				evictions = nil
+				continue
+			}
+			c.Ui.Error(fmt.Sprintf("Evicted by %s at %s (%s), terminating command",
+				e.Actor, e.When().Format(time.RFC3339), e.Reason))
+			ended = endEvicted
+			send(terminateSignal)
+			kill = time.After(limits.killAfter)
+		case <-kill:
+			c.Ui.Error("Command did not exit in time, killing it")
+			send(os.Kill)
+		}
+	}
 }
 
 func (c *ExecCommand) Synopsis() string {
@@ -85,20 +364,55 @@ func (c *ExecCommand) Help() string {
 	helpText := `
 Usage consul-semaphore exec [options] <command> [args...]
 
-  Executes command, wrapped in a consul semaphore.
+  Executes command, wrapped in a consul semaphore.  The command shares
+  consul-semaphore's stdin, stdout and stderr, and its environment is
+  extended with the SEMAPHORE_ variables described by "env".
+
+  SIGINT, SIGTERM, SIGHUP and SIGQUIT are forwarded to the command's process
+  group, and the semaphore is released once it exits, without waiting for
+  health checks; consul-semaphore then exits with 128+n for the first
+  signal n, whatever the command's exit status.  A signal received while
+  waiting for the semaphore abandons the wait.  If the holder is evicted
+  (see "evict") while the command runs, the command is terminated.
+
+  Exits with the command's exit status, or 128+n if it was killed by signal
+  n.  consul-semaphore's own failures use these exit codes:
+
+%s
 
 Options:
 
+	-wait-timeout              Wait at most this long for the semaphore, e.g.
+	                           15m, then exit with code 201
+	-health-check              Before releasing, wait for this check on the
+	                           local node to pass, may be repeated
+	-health-service            Before releasing, wait for all checks of this
+	                           service on the local node to pass
+	-health-timeout            How long to wait for checks, default 5m
+	-health-settle             How long to wait after the command exits
+	                           before looking at the checks, as Consul
+	                           reports them as they were before it ran until
+	                           they run again; at least the checks'
+	                           interval, default 10s
+	-health-failure            If checks don't pass in time: hold (keep the
+	                           semaphore), release, or freeze (stop further
+	                           acquires and release), default hold
+	-grace                     How long the command has to exit after being
+	                           signalled before it is killed, default 10s
+	-timeout                   Terminate the command if it runs longer than
+	                           this, e.g. 20m, and exit with code 205
+	-kill-after                How long a timed out command, or one whose
+	                           holder was evicted, has to exit before it is
+	                           killed, default 10s
+	-timeout-freeze            Freeze the semaphore if the command times out,
+	                           stopping further acquires
+	-prefix-output             Prefix each line the command outputs with the
+	                           time and holder
+%s
+%s
 %s
 	--                         Stop parsing args, next arg is command
 	`
 
-	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
-}
-
-/// WriterFunc is used to coerce Ui.Output() into the Writer interface.
-type WriterFunc func(p []byte) (n int, err error)
-
-func (wf WriterFunc) Write(p []byte) (n int, err error) {
-	return wf(p)
+	return strings.TrimSpace(fmt.Sprintf(helpText, exitHelp(), metricsHelp(), gateHelp(), commonHelp()))
 }
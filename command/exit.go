@@ -0,0 +1,81 @@
+package command
+
+import (
+	"os/exec"
+
+	"github.com/ryanschneider/consul-semaphore/agent"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// Exit codes for consul-semaphore's own failures.  exec otherwise exits with
+// its command's status, or 128+n if the command was killed by signal n, so
+// these are kept above the range signals use.
+const (
+	ExitError = 1
+
+	// ExitExhausted means the semaphore could not be acquired without
+	// waiting: it was exhausted, rate limited, cooling down, frozen, or
+	// held back by a health gate.
+	ExitExhausted = 200
+
+	// ExitAcquireTimeout means waiting for the semaphore timed out.
+	ExitAcquireTimeout = 201
+
+	// ExitConsulUnreachable means Consul could not be contacted.
+	ExitConsulUnreachable = 202
+
+	// ExitReleaseFailed means the semaphore could not be released.
+	ExitReleaseFailed = 203
+
+	// ExitUnhealthy means exec's health checks did not pass in time.
+	ExitUnhealthy = 204
+
+	// ExitCommandTimeout means exec's command ran past its -timeout.
+	ExitCommandTimeout = 205
+
+	// ExitEvicted means exec's holder was evicted while its command ran.
+	ExitEvicted = 206
+
+	// ExitCannotRun means exec could not start its command, as in the shell.
+	ExitCannotRun = 127
+)
+
+func exitHelp() string {
+	helpText := `
+	200                        Semaphore exhausted, rate limited, cooling
+	                           down, frozen, or held back by a health gate
+	201                        Timed out waiting for the semaphore
+	202                        Consul unreachable
+	203                        Releasing the semaphore failed
+	204                        Health checks did not pass after the command
+	205                        The command timed out
+	206                        The holder was evicted while the command ran
+	127                        The command could not be run
+`
+
+	return helpText[1 : len(helpText)-1]
+}
+
+// exitCode returns the exit code describing err, or fallback if err is not
+// one of the failures with a code of its own.
+func exitCode(err error, fallback int) int {
+	if _, ok := err.(*exec.Error); ok {
+		return ExitCannotRun
+	}
+
+	class := semaphore.Classify(err)
+	if e, ok := err.(agent.Error); ok {
+		// the agent's kinds name the class of the error it stands for
+		class = semaphore.Class(e.Kind)
+	}
+
+	switch class {
+	case semaphore.ClassExhausted:
+		return ExitExhausted
+	case semaphore.ClassTimeout:
+		return ExitAcquireTimeout
+	case semaphore.ClassUnreachable:
+		return ExitConsulUnreachable
+	}
+	return fallback
+}
@@ -0,0 +1,39 @@
+package command
+
+import (
+	"errors"
+	"net/url"
+	"os/exec"
+	"testing"
+
+	"github.com/ryanschneider/consul-semaphore/agent"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+func TestExitCode(t *testing.T) {
+	tests := []struct {
+		err  error
+		want int
+	}{
+		{lock.SemaphoreExhaustedErr(0), ExitExhausted},
+		{lock.ErrFrozen, ExitExhausted},
+		{lock.RateLimitedErr(0), ExitExhausted},
+		{semaphore.GateClosedErr{Reason: "web failing"}, ExitExhausted},
+		{semaphore.ErrAcquireTimeout, ExitAcquireTimeout},
+		{&url.Error{Op: "Get", URL: "http://127.0.0.1:8500", Err: errors.New("connection refused")}, ExitConsulUnreachable},
+		{&exec.Error{Name: "missing", Err: exec.ErrNotFound}, ExitCannotRun},
+		{agent.Error{Kind: agent.KindExhausted, Message: "exhausted"}, ExitExhausted},
+		{agent.Error{Kind: agent.KindTimeout, Message: "timed out"}, ExitAcquireTimeout},
+		{agent.Error{Kind: agent.KindUnreachable, Message: "unreachable"}, ExitConsulUnreachable},
+		{agent.Error{Kind: agent.KindNotHeld, Message: "not held"}, ExitReleaseFailed},
+		{lock.ErrNotExist, ExitReleaseFailed},
+		{errors.New("boom"), ExitReleaseFailed},
+	}
+
+	for _, test := range tests {
+		if got := exitCode(test.err, ExitReleaseFailed); got != test.want {
+			t.Errorf("%v: got %d, want %d", test.err, got, test.want)
+		}
+	}
+}
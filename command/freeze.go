@@ -0,0 +1,108 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/mitchellh/cli"
+)
+
+// FreezeCommand stops a semaphore from granting acquisitions.
+type FreezeCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *FreezeCommand) Run(args []string) int {
+	var reason string
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&reason, "reason", "", "why the semaphore is frozen")
+	})
+	if err != nil {
+		return 1
+	}
+
+	sem, _, err := parser.semaphore()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return 1
+	}
+
+	if reason == "" {
+		reason = fmt.Sprintf("frozen by %s", parser.Holder)
+	}
+
+	err = sem.Freeze(reason)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error freezing semaphore: %s", err))
+		return 1
+	}
+
+	return 0
+}
+
+func (c *FreezeCommand) Synopsis() string {
+	return "Stops a semaphore from granting acquisitions"
+}
+
+func (c *FreezeCommand) Help() string {
+	helpText := `
+Usage consul-semaphore freeze [options]
+
+  Stops a semaphore from granting acquisitions until it is unfrozen.
+  Current holders keep their share and may still release it.
+
+Options:
+
+	-reason                    Why the semaphore is frozen
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
+
+// UnfreezeCommand lets a frozen semaphore grant acquisitions again.
+type UnfreezeCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *UnfreezeCommand) Run(args []string) int {
+	parser, err := newParser(c.Name, args, nil)
+	if err != nil {
+		return 1
+	}
+
+	sem, _, err := parser.semaphore()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return 1
+	}
+
+	err = sem.Unfreeze()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error unfreezing semaphore: %s", err))
+		return 1
+	}
+
+	return 0
+}
+
+func (c *UnfreezeCommand) Synopsis() string {
+	return "Lets a frozen semaphore grant acquisitions again"
+}
+
+func (c *UnfreezeCommand) Help() string {
+	helpText := `
+Usage consul-semaphore unfreeze [options]
+
+  Lets a frozen semaphore grant acquisitions again.
+
+Options:
+
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
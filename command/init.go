@@ -4,9 +4,9 @@ import (
 	"flag"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/mitchellh/cli"
-	"github.com/ryanschneider/consul-semaphore/semaphore"
 )
 
 type InitCommand struct {
@@ -15,9 +15,15 @@ type InitCommand struct {
 }
 
 func (c *InitCommand) Run(args []string) int {
-	var max int
+	var max, rate int
+	var window, cooldown time.Duration
+	var numbered bool
 	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
 		f.IntVar(&max, "max", -0xdefa, "maximum concurrent")
+		f.IntVar(&rate, "rate", -0xdefa, "maximum acquisitions per window")
+		f.DurationVar(&window, "window", 0, "rate limit window")
+		f.DurationVar(&cooldown, "cooldown", -1, "minimum time between grants")
+		f.BoolVar(&numbered, "numbered", false, "give holders numbered slots")
 	})
 	if err != nil {
 		return 1
@@ -28,18 +34,60 @@ func (c *InitCommand) Run(args []string) int {
 		return 1
 	}
 
-	sem, err := semaphore.New(parser.Path, parser.Holder)
+	if rate < 0 && rate != -0xdefa {
+		c.Ui.Error(fmt.Sprintf("Rate must be a positive integer: %v", rate))
+		return 1
+	}
+
+	if rate > 0 && window <= 0 {
+		c.Ui.Error("A positive -window is required with -rate")
+		return 1
+	}
+
+	if cooldown < 0 && cooldown != -1 {
+		c.Ui.Error(fmt.Sprintf("Cooldown must not be negative: %v", cooldown))
+		return 1
+	}
+
+	sem, _, err := parser.semaphore()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
 		return 1
 	}
 
+	if parser.isSet("numbered") {
+		err = sem.SetNumbered(numbered)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error setting slot numbering for semaphore: %s", err))
+			return 1
+		}
+	}
+
 	if max > 0 {
-		_, err = sem.SetMax(uint(max))
+		change, err := sem.ChangeMax(uint(max), "")
 		if err != nil {
 			c.Ui.Error(fmt.Sprintf("Error setting maximum for semaphore: %s", err))
 			return 1
 		}
+		for holder, slot := range change.Over {
+			c.Ui.Warn(fmt.Sprintf("Holder %s has slot %d, over the new maximum", holder, slot))
+		}
+	}
+
+	if rate != -0xdefa {
+		err = sem.SetRate(uint(rate), window)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error setting rate limit for semaphore: %s", err))
+			return 1
+		}
+	}
+
+	if cooldown >= 0 {
+		err = sem.SetCooldown(cooldown)
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error setting cooldown for semaphore: %s", err))
+			return 1
+		}
 	}
 	return 0
 }
@@ -52,11 +100,18 @@ func (c *InitCommand) Help() string {
 	helpText := `
 Usage consul-semaphore init [options]
 
-	Initializes a unowned semaphore in Consul.
+	Initializes a unowned semaphore in Consul.  To change the max of a
+	semaphore in use, prefer set-max.
 
 Options:
 
 	-max                       Maximum concurrent, default 1
+	-rate                      Maximum acquisitions per -window, 0 to remove
+	-window                    Rate limit window, e.g. 10m
+	-cooldown                  Minimum time between a release and the next
+	                           acquire, e.g. 2m, 0 to remove
+	-numbered                  Give each holder the lowest free slot number
+	                           in [0, max), -numbered=false to stop
 %s
 	`
 
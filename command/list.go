@@ -0,0 +1,166 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// ListCommand summarizes every semaphore under a KV prefix.
+type ListCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+// listEntry is a semaphore as shown by list.
+type listEntry struct {
+	Path         string  `json:"path"`
+	Max          int     `json:"max"`
+	InUse        int     `json:"inUse"`
+	Frozen       bool    `json:"frozen"`
+	OldestHolder string  `json:"oldestHolder,omitempty"`
+	OldestAge    string  `json:"oldestAge,omitempty"`
+	OldestSecs   float64 `json:"oldestAgeSeconds,omitempty"`
+}
+
+func newListEntry(path string, sem *lock.Semaphore, now time.Time) listEntry {
+	e := listEntry{
+		Path:   path,
+		Max:    sem.Max,
+		InUse:  len(sem.Holders),
+		Frozen: sem.Frozen,
+	}
+
+	var oldest time.Time
+	for _, h := range sem.Holders {
+		info, ok := sem.HolderInfo[h]
+		if ok && (oldest.IsZero() || info.Started().Before(oldest)) {
+			oldest = info.Started()
+			e.OldestHolder = h
+		}
+	}
+	if e.OldestHolder != "" {
+		age := now.Sub(oldest)
+		e.OldestAge = age.Truncate(time.Second).String()
+		e.OldestSecs = age.Seconds()
+	}
+
+	return e
+}
+
+func (c *ListCommand) Run(args []string) int {
+	var prefix, format string
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&prefix, "prefix", "", "KV prefix to list semaphores under")
+		f.StringVar(&format, "format", "table", "output format, table or json")
+	})
+	if err != nil {
+		return 1
+	}
+
+	if prefix == "" {
+		c.Ui.Error("Error: -prefix is required")
+		return 1
+	}
+	if format != "table" && format != "json" {
+		c.Ui.Error(fmt.Sprintf("Unknown -format: %s", format))
+		return 1
+	}
+
+	client, err := parser.consulClient()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	sems, invalid, err := semaphore.List(client, prefix)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error listing semaphores: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	now := time.Now()
+	entries := []listEntry{}
+	for path, sem := range sems {
+		entries = append(entries, newListEntry(path, sem, now))
+	}
+	sort.Sort(byPath(entries))
+	sort.Strings(invalid)
+
+	if format == "json" {
+		if invalid == nil {
+			invalid = []string{}
+		}
+		b, err := json.MarshalIndent(map[string]interface{}{
+			"semaphores": entries,
+			"invalid":    invalid,
+		}, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error encoding list: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(b))
+		return 0
+	}
+
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "PATH\tMAX\tIN USE\tOLDEST HOLDER\tAGE")
+	for _, e := range entries {
+		path := e.Path
+		if e.Frozen {
+			path += " (frozen)"
+		}
+		oldest, age := "-", "-"
+		if e.OldestHolder != "" {
+			oldest, age = e.OldestHolder, e.OldestAge
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\n", path, e.Max, e.InUse, oldest, age)
+	}
+	w.Flush()
+	c.Ui.Output(strings.TrimRight(b.String(), "\n"))
+
+	for _, key := range invalid {
+		c.Ui.Warn(fmt.Sprintf("Not a semaphore: %s", key))
+	}
+
+	return 0
+}
+
+type byPath []listEntry
+
+func (p byPath) Len() int           { return len(p) }
+func (p byPath) Less(i, j int) bool { return p[i].Path < p[j].Path }
+func (p byPath) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+
+func (c *ListCommand) Synopsis() string {
+	return "Lists the semaphores under a KV prefix"
+}
+
+func (c *ListCommand) Help() string {
+	helpText := `
+Usage consul-semaphore list -prefix <prefix> [options]
+
+  Lists every semaphore under a KV prefix, with its max, the number of
+  holders, and the holder that has held it longest.  Keys under the prefix
+  that are not semaphores are reported as such.  All keys are read in a
+  single, possibly stale, request.
+
+Options:
+
+	-prefix                    KV prefix to list, e.g. semaphores/
+	-format                    Output format, table (default) or json
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
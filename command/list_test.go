@@ -0,0 +1,61 @@
+package command
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+func TestNewListEntry(t *testing.T) {
+	now := time.Unix(2000, 0)
+	at := func(sec int64) lock.Holder { return lock.Holder{StartTime: time.Unix(sec, 0).UnixNano()} }
+
+	tests := []struct {
+		name string
+		sem  lock.Semaphore
+		want listEntry
+	}{
+		{
+			name: "idle",
+			sem:  lock.Semaphore{Max: 2, Semaphore: 2},
+			want: listEntry{Path: "p", Max: 2},
+		},
+		{
+			name: "frozen",
+			sem:  lock.Semaphore{Max: 1, Semaphore: 1, Frozen: true},
+			want: listEntry{Path: "p", Max: 1, Frozen: true},
+		},
+		{
+			name: "oldest holder",
+			sem: lock.Semaphore{
+				Max:        3,
+				Holders:    []string{"a", "b", "c"},
+				HolderInfo: map[string]lock.Holder{"a": at(1950), "b": at(1900), "c": at(1990)},
+			},
+			want: listEntry{Path: "p", Max: 3, InUse: 3, OldestHolder: "b", OldestAge: "1m40s", OldestSecs: 100},
+		},
+		{
+			// holders that locked before their info was recorded have no
+			// known age
+			name: "unrecorded holder",
+			sem: lock.Semaphore{
+				Max:        2,
+				Holders:    []string{"a", "b"},
+				HolderInfo: map[string]lock.Holder{"b": at(1999)},
+			},
+			want: listEntry{Path: "p", Max: 2, InUse: 2, OldestHolder: "b", OldestAge: "1s", OldestSecs: 1},
+		},
+		{
+			name: "no info",
+			sem:  lock.Semaphore{Max: 1, Holders: []string{"a"}},
+			want: listEntry{Path: "p", Max: 1, InUse: 1},
+		},
+	}
+
+	for _, test := range tests {
+		if got := newListEntry("p", &test.sem, now); got != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package command
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/ryanschneider/consul-semaphore/metrics"
+)
+
+// serveMetrics serves Prometheus metrics at /metrics on addr, in the
+// background for as long as the command runs.
+func serveMetrics(addr string) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	go http.Serve(l, mux)
+	return nil
+}
+
+func metricsHelp() string {
+	helpText := `
+	-metrics-addr              Serve Prometheus metrics at /metrics on this
+	                           address, e.g. 127.0.0.1:9700
+`
+
+	return helpText[1 : len(helpText)-1]
+}
@@ -5,15 +5,35 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/agent"
+	"github.com/ryanschneider/consul-semaphore/backoff"
+	"github.com/ryanschneider/consul-semaphore/health"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/logging"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
 )
 
 type Parser struct {
-	Consul  string
-	Path    string
-	Holder  string
-	Verbose bool
+	Consul    string
+	Path      string
+	Holder    string
+	Agent     string
+	Verbose   bool
+	LogLevel  string
+	LogFormat string
+	Config    string
+	Profile   string
+	Backoff   backoff.Policy
+
+	flags   *flag.FlagSet
+	backoff backoffFlags
 
-	flags *flag.FlagSet
+	// given has the flags given on the command line.
+	given map[string]bool
 }
 
 func newParser(name string, args []string, addFlags func(*flag.FlagSet)) (parser *Parser, err error) {
@@ -28,7 +48,24 @@ func newParser(name string, args []string, addFlags func(*flag.FlagSet)) (parser
 		"KV path to the semaphore to use")
 	parser.flags.StringVar(&parser.Holder, "holder", "",
 		"the holder of the semaphore (default hostname)")
+	parser.flags.StringVar(&parser.Agent, "agent", agent.DefaultSocket(),
+		"socket of the agent to use when it is running")
 	parser.flags.BoolVar(&parser.Verbose, "verbose", false, "enables verbose output")
+	parser.flags.StringVar(&parser.LogLevel, "log-level", "warn",
+		"lowest level to log: debug, info, warn or error")
+	parser.flags.StringVar(&parser.LogFormat, "log-format", logging.FormatText,
+		"log format: text or json")
+	parser.flags.StringVar(&parser.Config, "config", os.Getenv(configEnv),
+		"config file of default flag values and profiles")
+	parser.flags.StringVar(&parser.Profile, "profile", os.Getenv(profileEnv),
+		"profile from the config file to use")
+	parser.backoff.addFlags(parser.flags)
+
+	// the flags so far are common to every command
+	common := make(map[string]bool)
+	parser.flags.VisitAll(func(f *flag.Flag) {
+		common[f.Name] = true
+	})
 
 	//call setupFunc if supplied
 	if addFlags != nil {
@@ -42,6 +79,28 @@ func newParser(name string, args []string, addFlags func(*flag.FlagSet)) (parser
 		}
 	}
 
+	// flags given on the command line win over the config file and
+	// environment
+	parser.given = make(map[string]bool)
+	parser.flags.Visit(func(f *flag.Flag) {
+		parser.given[f.Name] = true
+	})
+	config, err := loadConfig(parser.Config)
+	if err == nil {
+		err = config.apply(parser.flags, name, common, parser.given, parser.Profile)
+	}
+	if err == nil {
+		err = parser.setupLogging()
+	}
+	if err == nil {
+		parser.Backoff, err = parser.backoff.policy()
+	}
+	if err != nil {
+		// commands only report flag errors through the flag package
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		return nil, err
+	}
+
 	if parser.Holder == "" {
 		hostname, err := os.Hostname()
 		if err != nil {
@@ -54,13 +113,245 @@ func newParser(name string, args []string, addFlags func(*flag.FlagSet)) (parser
 	return
 }
 
+// setupLogging makes the default logger log as -log-level and -log-format
+// say.  -verbose is -log-level debug, unless -log-level is given on the
+// command line too.
+func (p *Parser) setupLogging() error {
+	name := p.LogLevel
+	if p.Verbose && !p.given["log-level"] {
+		name = "debug"
+	}
+	level, err := logging.ParseLevel(name)
+	if err != nil {
+		return err
+	}
+
+	logger, err := logging.New(os.Stderr, level, p.LogFormat)
+	if err != nil {
+		return err
+	}
+
+	logging.SetDefault(logger)
+	return nil
+}
+
+// isSet reports whether the named flag was given on the command line, or
+// set from the config file or environment.
+func (p *Parser) isSet(name string) (set bool) {
+	p.flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// consulClient returns a Consul API client for the -consul address.
+func (p *Parser) consulClient() (*api.Client, error) {
+	config := api.DefaultConfig()
+	config.Address = p.Consul
+	return api.NewClient(config)
+}
+
+// semaphore returns the Semaphore at -path for -holder, along with the
+// Consul client backing it.
+func (p *Parser) semaphore() (*semaphore.Semaphore, *api.Client, error) {
+	client, err := p.consulClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sem, err := semaphore.NewWithClient(p.Path, p.Holder, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	sem.SetBackoff(p.Backoff)
+
+	return sem, client, nil
+}
+
+// existing returns the Semaphore at -path for watching, and its current
+// state, failing with lock.SemaphoreNotFoundErr rather than creating it if
+// there is none.
+func (p *Parser) existing() (*semaphore.Semaphore, *lock.Semaphore, error) {
+	client, err := p.consulClient()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	state, err := semaphore.Inspect(client, p.Path, true)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sem, err := semaphore.Open(p.Path, p.Holder, client)
+	if err != nil {
+		return nil, nil, err
+	}
+	sem.SetBackoff(p.Backoff)
+
+	return sem, state, nil
+}
+
+// agent returns a client for the agent at -agent, or nil if it isn't
+// running or -agent is empty.
+func (p *Parser) agent() *agent.Client {
+	if p.Agent == "" {
+		return nil
+	}
+	client, err := agent.Dial(p.Agent)
+	if errors.Is(err, agent.ErrUntrusted) {
+		logging.Default().Log(logging.Warn, "not using the agent", logging.F("error", err))
+	}
+	if err != nil {
+		return nil
+	}
+	return client
+}
+
+// agentRequest returns a request to the agent for -path and -holder.
+func (p *Parser) agentRequest() agent.Request {
+	return agent.Request{Consul: p.Consul, Path: p.Path, Holder: p.Holder}
+}
+
+// gateFlags hold back acquiring while a service is unhealthy cluster-wide.
+type gateFlags struct {
+	service    string
+	minPassing int
+}
+
+func (g *gateFlags) addFlags(f *flag.FlagSet) {
+	f.StringVar(&g.service, "require-service", "", "service that must be healthy to acquire")
+	f.IntVar(&g.minPassing, "require-passing", 0, "instances of the service that must pass")
+}
+
+// apply adds the gates asked for to sem.
+func (g *gateFlags) apply(sem *semaphore.Semaphore, client *api.Client) error {
+	if g.service == "" {
+		if g.minPassing != 0 {
+			return errors.New("-require-passing needs -require-service")
+		}
+		return nil
+	}
+	if g.minPassing < 0 {
+		return fmt.Errorf("-require-passing must be a positive integer: %v", g.minPassing)
+	}
+
+	sem.AddGate(&health.ServiceGate{
+		Client:     client,
+		Service:    g.service,
+		MinPassing: g.minPassing,
+	})
+	return nil
+}
+
+func gateHelp() string {
+	helpText := `
+	-require-service           Only acquire while every instance of this
+	                           service is passing its checks, and at least
+	                           one is registered
+	-require-passing           With -require-service, instead only acquire
+	                           while at least this many instances are passing
+`
+
+	return helpText[1 : len(helpText)-1]
+}
+
+// backoffFlags describe how to back off between retries.
+type backoffFlags struct {
+	kind     string
+	jitter   string
+	base     time.Duration
+	max      time.Duration
+	attempts int
+	elapsed  time.Duration
+}
+
+func (b *backoffFlags) addFlags(f *flag.FlagSet) {
+	f.StringVar(&b.kind, "backoff", "constant", "backoff between retries: constant or exponential")
+	f.StringVar(&b.jitter, "backoff-jitter", "full", "jitter: none, full or decorrelated")
+	f.DurationVar(&b.base, "backoff-base", time.Second, "delay, or first delay, between retries")
+	f.DurationVar(&b.max, "backoff-max", 30*time.Second, "longest exponential delay")
+	f.IntVar(&b.attempts, "backoff-attempts", 0, "retries before giving up, 0 for no limit")
+	f.DurationVar(&b.elapsed, "backoff-elapsed", 5*time.Minute, "time to retry for, 0 for no limit")
+}
+
+// policy returns the backoff policy the flags describe.
+func (b *backoffFlags) policy() (backoff.Policy, error) {
+	jitters := map[string]backoff.Jitter{
+		"none":         backoff.NoJitter,
+		"full":         backoff.FullJitter,
+		"decorrelated": backoff.DecorrelatedJitter,
+	}
+	jitter, ok := jitters[b.jitter]
+	if !ok {
+		return nil, fmt.Errorf("unknown -backoff-jitter %q", b.jitter)
+	}
+	if b.base <= 0 || b.attempts < 0 || b.elapsed < 0 {
+		return nil, errors.New("-backoff-base must be positive, and -backoff-attempts and -backoff-elapsed not negative")
+	}
+
+	var p backoff.Policy
+	switch b.kind {
+	case "constant":
+		if jitter == backoff.DecorrelatedJitter {
+			return nil, errors.New("-backoff-jitter decorrelated needs -backoff exponential")
+		}
+		p = backoff.Constant{Delay: b.base, Jitter: jitter == backoff.FullJitter}
+	case "exponential":
+		p = backoff.Exponential{Base: b.base, Max: b.max, Jitter: jitter}
+	default:
+		return nil, fmt.Errorf("unknown -backoff %q", b.kind)
+	}
+
+	return backoff.Limit{Policy: p, MaxAttempts: b.attempts, MaxElapsed: b.elapsed}, nil
+}
+
+func backoffHelp() string {
+	helpText := `
+	-backoff                   How to back off between retries after
+	                           conflicting writes or failing to reach
+	                           Consul: constant (default) or exponential
+	-backoff-jitter            Randomize delays: none, full (default), or
+	                           decorrelated, with exponential
+	-backoff-base              The delay, or first delay, default 1s
+	-backoff-max               The longest exponential delay, default 30s
+	-backoff-attempts          Give up after this many retries, default 0
+	                           for no limit
+	-backoff-elapsed           Give up after retrying this long, default 5m,
+	                           0 for no limit
+`
+
+	return helpText[1 : len(helpText)-1]
+}
+
+// stringList is a flag.Value collecting every use of a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
 func commonHelp() string {
 	helpText := `
 	-path                      KV path to the semaphore to use
 	-holder                    The name of the holder (defaults to hostname)
 	-consul                    Consul server to use, defaults to localhost:8500
-	-verbose                   Enables verbose output
+	-agent                     Socket of the agent to go through when it is
+	                           running; empty to never use one
+	-verbose                   Enables verbose output, as -log-level debug
+	-log-level                 Lowest level to log: debug, info, warn
+	                           (default) or error
+	-log-format                Log as text (default) or json
+%s
+%s
 `
 
+	helpText = fmt.Sprintf(helpText, backoffHelp(), configHelp())
 	return helpText[1 : len(helpText)-1]
 }
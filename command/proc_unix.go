@@ -0,0 +1,57 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts cmd in its own process group, so that signals can
+// be forwarded to it and anything it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// signalProcessGroup sends sig to cmd's process group, or just to cmd if it
+// was not started in a group of its own.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	s, ok := sig.(syscall.Signal)
+	if !ok || cmd.SysProcAttr == nil || !cmd.SysProcAttr.Setpgid {
+		return cmd.Process.Signal(sig)
+	}
+	return syscall.Kill(-cmd.Process.Pid, s)
+}
+
+// terminateSignal asks a command to exit.
+var terminateSignal os.Signal = syscall.SIGTERM
+
+// forwardedSignals are passed on to the command run by exec.
+var forwardedSignals = []os.Signal{
+	syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT,
+}
+
+// terminalSignals are sent by a terminal to its whole foreground process
+// group, so a command sharing our group already has them.
+var terminalSignals = map[os.Signal]bool{
+	syscall.SIGINT: true, syscall.SIGHUP: true, syscall.SIGQUIT: true,
+}
+
+// exitStatus returns the status a shell would report for a finished
+// process: its exit code, or 128+n if it was killed by signal n.
+func exitStatus(state *os.ProcessState) int {
+	if ws, ok := state.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		return 128 + int(ws.Signal())
+	}
+	return state.ExitCode()
+}
+
+// signalExitCode is the status a shell reports for death by sig.
+func signalExitCode(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return 128 + int(s)
+	}
+	return ExitError
+}
@@ -0,0 +1,34 @@
+//go:build !windows
+// +build !windows
+
+package command
+
+import (
+	"os/exec"
+	"syscall"
+	"testing"
+)
+
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		script string
+		want   int
+	}{
+		{"exit 0", 0},
+		{"exit 3", 3},
+		{"kill -TERM $$", 128 + int(syscall.SIGTERM)},
+		{"kill -KILL $$", 128 + int(syscall.SIGKILL)},
+	}
+
+	for _, test := range tests {
+		cmd := exec.Command("/bin/sh", "-c", test.script)
+		cmd.Run()
+		if got := exitStatus(cmd.ProcessState); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.script, got, test.want)
+		}
+	}
+
+	if got := signalExitCode(syscall.SIGINT); got != 128+int(syscall.SIGINT) {
+		t.Errorf("SIGINT: got %d, want %d", got, 128+int(syscall.SIGINT))
+	}
+}
@@ -0,0 +1,34 @@
+package command
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op, Windows has no process groups to signal.
+func setProcessGroup(cmd *exec.Cmd) {
+}
+
+// signalProcessGroup sends sig to cmd; only os.Kill is supported.
+func signalProcessGroup(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Signal(sig)
+}
+
+// terminateSignal asks a command to exit.
+var terminateSignal os.Signal = os.Kill
+
+// forwardedSignals are passed on to the command run by exec.
+var forwardedSignals = []os.Signal{os.Interrupt}
+
+// terminalSignals are delivered to the command by the console itself.
+var terminalSignals = map[os.Signal]bool{os.Interrupt: true}
+
+// exitStatus returns the exit code of a finished process.
+func exitStatus(state *os.ProcessState) int {
+	return state.ExitCode()
+}
+
+// signalExitCode is the status to exit with after being interrupted.
+func signalExitCode(sig os.Signal) int {
+	return ExitError
+}
@@ -0,0 +1,195 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/health"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// ReapCommand evicts holders whose Consul node has left or is failing.
+type ReapCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *ReapCommand) Run(args []string) int {
+	var (
+		prefix    string
+		grace     time.Duration
+		every     time.Duration
+		dryRun    bool
+		unmatched bool
+	)
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&prefix, "prefix", "", "reap every semaphore under this KV prefix")
+		f.DurationVar(&grace, "grace", 5*time.Minute, "how long a node may fail before its holders are reaped")
+		f.DurationVar(&every, "every", 0, "keep reaping at this interval")
+		f.BoolVar(&dryRun, "dry-run", false, "only report the holders that would be reaped")
+		f.BoolVar(&unmatched, "reap-unmatched", false, "also reap holders that match no node")
+	})
+	if err != nil {
+		return 1
+	}
+
+	client, err := parser.consulClient()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	reaper := &health.Reaper{Client: client, Grace: grace, Unmatched: unmatched}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	sig, err := interruptible(signals, func(ctx context.Context) error {
+		for {
+			recheck, err := c.reap(client, reaper, parser, prefix, dryRun)
+			if err != nil {
+				if every == 0 {
+					return err
+				}
+				c.Ui.Error(fmt.Sprintf("Error reaping: %s", err))
+			}
+
+			// A single pass still waits out the grace of failing nodes.
+			wait := every
+			if every == 0 && recheck == 0 {
+				return nil
+			}
+			if wait == 0 || (recheck > 0 && recheck < wait) {
+				wait = recheck
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(wait):
+			}
+		}
+	})
+	if sig != nil && every == 0 {
+		c.Ui.Error(fmt.Sprintf("Received %v, giving up", sig))
+		return signalExitCode(sig)
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reaping: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	return 0
+}
+
+// reap makes a single pass over the semaphores, evicting holders the reaper
+// picks on -holder's behalf.
+func (c *ReapCommand) reap(client *api.Client, reaper *health.Reaper, parser *Parser, prefix string, dryRun bool) (recheck time.Duration, err error) {
+	var sems map[string]*lock.Semaphore
+	if prefix != "" {
+		var invalid []string
+		sems, invalid, err = semaphore.List(client, prefix)
+		for _, key := range invalid {
+			c.Ui.Warn(fmt.Sprintf("Not a semaphore: %s", key))
+		}
+	} else {
+		var sem *lock.Semaphore
+		sem, err = semaphore.Inspect(client, parser.Path, false)
+		sems = map[string]*lock.Semaphore{parser.Path: sem}
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var paths, holders []string
+	for path, sem := range sems {
+		paths = append(paths, path)
+		holders = append(holders, sem.Holders...)
+	}
+	sort.Strings(paths)
+
+	reap, unknown, recheck, err := reaper.Check(holders)
+	if err != nil {
+		return 0, err
+	}
+	for _, h := range unknown {
+		c.Ui.Warn(fmt.Sprintf("Skipping unknown holder %s: it matches no node in the Consul catalog", h))
+	}
+
+	for _, path := range paths {
+		for _, h := range sems[path].Holders {
+			reason, ok := reap[h]
+			if !ok {
+				continue
+			}
+			if dryRun {
+				c.Ui.Output(fmt.Sprintf("Would evict %s from %s: %s", h, path, reason))
+				continue
+			}
+
+			sem, err := semaphore.NewWithClient(path, parser.Holder, client)
+			if err == nil {
+				sem.SetBackoff(parser.Backoff)
+				err = sem.Evict(h, reason)
+			}
+			switch err {
+			case nil:
+				c.Ui.Output(fmt.Sprintf("Evicted %s from %s: %s", h, path, reason))
+			case lock.ErrNotExist:
+				// released since we looked
+			default:
+				c.Ui.Error(fmt.Sprintf("Error evicting %s from %s: %s", h, path, err))
+			}
+		}
+	}
+
+	return recheck, nil
+}
+
+func (c *ReapCommand) Synopsis() string {
+	return "Evicts holders whose Consul node has left or is failing"
+}
+
+func (c *ReapCommand) Help() string {
+	helpText := `
+Usage consul-semaphore reap [options]
+
+  Evicts holders whose Consul node has failed its serfHealth check for
+  longer than -grace, so their share of the semaphore is not lost with the
+  machine.  Holders are matched to nodes by name, as they are named after
+  the hostname by default.  A holder that matches no node in the catalog,
+  whether its node has left or it was given a name of its own, is reported
+  as unknown and skipped, unless -reap-unmatched is given.
+
+  Each eviction is recorded in the semaphore's audit trail, made by -holder.
+  How long a node has been failing is counted from when reap first sees it
+  fail, so a single pass waits out -grace for nodes that are failing, and
+  with -reap-unmatched, for holders that match no node.
+
+Options:
+
+	-prefix                    Reap every semaphore under this KV prefix,
+	                           rather than the one at -path
+	-grace                     How long a node may fail its serfHealth check
+	                           before its holders are evicted, default 5m
+	-every                     Keep running, reaping at this interval,
+	                           until interrupted
+	-dry-run                   Only report the holders that would be evicted
+	-reap-unmatched            Also evict holders that have matched no node
+	                           for longer than -grace.  Only use this when
+	                           every holder is named after its node
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
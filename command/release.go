@@ -5,7 +5,6 @@ import (
 	"strings"
 
 	"github.com/mitchellh/cli"
-	"github.com/ryanschneider/consul-semaphore/semaphore"
 )
 
 type ReleaseCommand struct {
@@ -19,16 +18,24 @@ func (c *ReleaseCommand) Run(args []string) int {
 		return 1
 	}
 
-	sem, err := semaphore.New(parser.Path, parser.Holder)
+	if ac := parser.agent(); ac != nil {
+		if err := ac.Release(parser.agentRequest()); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error releasing semaphore: %s", err))
+			return exitCode(err, ExitReleaseFailed)
+		}
+		return 0
+	}
+
+	sem, _, err := parser.semaphore()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
-		return 1
+		return exitCode(err, ExitError)
 	}
 
 	err = sem.Release()
 	if err != nil {
 		c.Ui.Error(fmt.Sprintf("Error releasing semaphore: %s", err))
-		return 1
+		return exitCode(err, ExitReleaseFailed)
 	}
 
 	return 0
@@ -42,7 +49,8 @@ func (c *ReleaseCommand) Help() string {
 	helpText := `
 Usage consul-semaphore release [options]
 
-  Releases a previously acquired semaphore in consul.
+  Releases a previously acquired semaphore in consul, through the agent if
+  one is running.
 
 Options:
 
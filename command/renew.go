@@ -0,0 +1,66 @@
+package command
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+// RenewCommand extends a semaphore held through the agent.
+type RenewCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *RenewCommand) Run(args []string) int {
+	var ttl time.Duration
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.DurationVar(&ttl, "ttl", 0, "new time to live of the hold")
+	})
+	if err != nil {
+		return 1
+	}
+
+	ac := parser.agent()
+	if ac == nil {
+		c.Ui.Error(fmt.Sprintf("Error: no agent is running on %s", parser.Agent))
+		return 1
+	}
+
+	req := parser.agentRequest()
+	req.TTL = ttl
+	hold, err := ac.Renew(req)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error renewing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	if hold.Expires != nil {
+		c.Ui.Output(fmt.Sprintf("Held until %s", hold.Expires.Format(time.RFC3339)))
+	}
+	return 0
+}
+
+func (c *RenewCommand) Synopsis() string {
+	return "Renews a semaphore held through the agent"
+}
+
+func (c *RenewCommand) Help() string {
+	helpText := `
+Usage consul-semaphore renew [options]
+
+  Restarts the time to live of a semaphore acquired through the agent
+  with -ttl, so the agent keeps holding it.
+
+Options:
+
+	-ttl                       The new time to live, default the one given
+	                           when acquiring
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
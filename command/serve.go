@@ -0,0 +1,125 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/server"
+)
+
+// tokenEnv names the environment variable serve reads its token from.
+const tokenEnv = "CONSUL_SEMAPHORE_TOKEN"
+
+// ServeCommand serves semaphores over HTTP.
+type ServeCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *ServeCommand) Run(args []string) int {
+	var addr, prefix, token, metricsAddr string
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&addr, "addr", "127.0.0.1:8700", "address to listen on")
+		f.StringVar(&prefix, "prefix", "", "KV prefix the semaphores served must be under")
+		f.StringVar(&token, "token", os.Getenv(tokenEnv), "bearer token clients must send")
+		f.StringVar(&metricsAddr, "metrics-addr", "", "address to serve metrics on")
+	})
+	if err != nil {
+		return 1
+	}
+
+	if strings.Trim(prefix, "/") == "" {
+		c.Ui.Error("Error: -prefix is required, so clients can't write to other keys")
+		return 1
+	}
+
+	client, err := parser.consulClient()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error connecting to Consul: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	if metricsAddr != "" {
+		if err := serveMetrics(metricsAddr); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error serving metrics: %s", err))
+			return 1
+		}
+	}
+
+	if token == "" {
+		c.Ui.Warn("No -token given, anyone who can reach the server can use it")
+	}
+
+	srv := &http.Server{Addr: addr, Handler: &server.Server{Client: client, Prefix: prefix, Token: token, Backoff: parser.Backoff}}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	c.Ui.Output(fmt.Sprintf("Serving semaphores on %s", addr))
+	sig, err := interruptible(signals, func(ctx context.Context) error {
+		go func() {
+			<-ctx.Done()
+			srv.Close()
+		}()
+		return srv.ListenAndServe()
+	})
+	if sig != nil {
+		c.Ui.Output(fmt.Sprintf("Received %v, shutting down", sig))
+		return 0
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error serving: %s", err))
+		return 1
+	}
+
+	return 0
+}
+
+func (c *ServeCommand) Synopsis() string {
+	return "Serves semaphores over HTTP"
+}
+
+func (c *ServeCommand) Help() string {
+	helpText := `
+Usage consul-semaphore serve -prefix <prefix> [options]
+
+  Serves semaphores over HTTP with JSON bodies, for clients without a
+  Consul client of their own:
+
+	GET /v1/semaphore/<path>            The semaphore's state, and its index
+	                                    in the X-Semaphore-Index header;
+	                                    with ?index=N, waits until it
+	                                    changes from index N
+	PUT /v1/semaphore/<path>/acquire    ?holder=H, optionally &wait=true or
+	                                    &timeout=30s
+	PUT /v1/semaphore/<path>/release    ?holder=H
+	PUT /v1/semaphore/<path>/max        ?max=N, optionally &reason=R and
+	                                    &holder=H for the audit trail
+
+  Only semaphores under -prefix are served; other paths are answered with
+  a 403.  Keys under it that hold anything but a semaphore are answered
+  with a 400 rather than overwritten.
+
+  Failures are answered with {"kind": ..., "error": ...}, and a 409 if the
+  semaphore could not be acquired, or 408 if waiting for it timed out.
+
+Options:
+
+	-prefix                    KV prefix the semaphores served must be under,
+	                           required
+	-addr                      Address to listen on, default 127.0.0.1:8700
+	-token                     Bearer token clients must send, default
+	                           $%s
+%s
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, tokenEnv, metricsHelp(), commonHelp()))
+}
@@ -0,0 +1,119 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+// SetMaxCommand changes the maximum number of holders of a semaphore.
+type SetMaxCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *SetMaxCommand) Run(args []string) int {
+	var (
+		max         int
+		allowZero   bool
+		reason      string
+		waitDrain   bool
+		waitTimeout time.Duration
+	)
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.IntVar(&max, "max", -1, "maximum concurrent")
+		f.BoolVar(&allowZero, "allow-zero", false, "allow a max of 0")
+		f.StringVar(&reason, "reason", "", "why the max is changing")
+		f.BoolVar(&waitDrain, "wait-drain", false, "wait for holders to fit the new max")
+		f.DurationVar(&waitTimeout, "wait-timeout", 0, "how long to wait for holders to drain")
+	})
+	if err != nil {
+		return 1
+	}
+
+	switch {
+	case !parser.isSet("max"):
+		c.Ui.Error("Error: -max is required")
+		return 1
+	case max < 0:
+		c.Ui.Error(fmt.Sprintf("Max must be a positive integer: %v", max))
+		return 1
+	case max == 0 && !allowZero:
+		c.Ui.Error("A max of 0 stops all acquires; pass -allow-zero if that is intended")
+		return 1
+	}
+
+	sem, _, err := parser.semaphore()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error initializing semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	change, err := sem.ChangeMax(uint(max), reason)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error setting maximum for semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	c.Ui.Output(fmt.Sprintf("Max for %s changed from %d to %d, %d holders",
+		sem.Path, change.Old, change.New, change.Holders))
+	for holder, slot := range change.Over {
+		c.Ui.Warn(fmt.Sprintf("Holder %s has slot %d, over the new maximum", holder, slot))
+	}
+
+	if !waitDrain || change.Holders <= max {
+		return 0
+	}
+
+	ctx := context.Background()
+	if waitTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, waitTimeout)
+		defer cancel()
+	}
+
+	c.Ui.Output(fmt.Sprintf("Waiting for %d holders to drain", change.Holders-max))
+	err = sem.WaitDrain(ctx)
+	if err == context.DeadlineExceeded {
+		c.Ui.Error(fmt.Sprintf("Timed out after %v waiting for holders to drain", waitTimeout))
+		return ExitAcquireTimeout
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error waiting for holders to drain: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	c.Ui.Output("Holders drained")
+	return 0
+}
+
+func (c *SetMaxCommand) Synopsis() string {
+	return "Changes the maximum concurrent holders of a semaphore"
+}
+
+func (c *SetMaxCommand) Help() string {
+	helpText := `
+Usage consul-semaphore set-max -max <n> [options]
+
+  Changes the maximum concurrent holders of a semaphore, and records the
+  change in its audit trail.  Lowering the max does not evict holders; use
+  -wait-drain to wait until they fit within it.
+
+Options:
+
+	-max                       The new maximum, required
+	-allow-zero                Allow a max of 0, which stops all acquires
+	-reason                    Why the max is changing, for the audit trail
+	-wait-drain                After lowering the max, wait until the
+	                           holders fit within it
+	-wait-timeout              Wait at most this long for holders to drain,
+	                           then exit with code 201
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
@@ -0,0 +1,269 @@
+package command
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// StatusCommand shows the state of a semaphore.
+type StatusCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+// holderStatus is a holder as shown by status.
+type holderStatus struct {
+	Name     string     `json:"name"`
+	Slot     *int       `json:"slot,omitempty"`
+	Acquired *time.Time `json:"acquired,omitempty"`
+	Held     string     `json:"held,omitempty"`
+	HeldSecs float64    `json:"heldSeconds,omitempty"`
+}
+
+// auditStatus is an audit trail entry as shown by status.
+type auditStatus struct {
+	Time   time.Time `json:"time"`
+	Action string    `json:"action"`
+	Actor  string    `json:"actor"`
+	Holder string    `json:"holder,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// statusAuditLines is how many audit entries the status table shows.
+const statusAuditLines = 5
+
+// rateStatus is a rate limit as shown by status.
+type rateStatus struct {
+	Limit    int    `json:"limit"`
+	Window   string `json:"window"`
+	InWindow int    `json:"inWindow"`
+}
+
+// semaphoreStatus is a semaphore as shown by status.
+type semaphoreStatus struct {
+	Path         string         `json:"path"`
+	Max          int            `json:"max"`
+	Available    int            `json:"available"`
+	Holders      []holderStatus `json:"holders"`
+	Waiters      []string       `json:"waiters"`
+	Numbered     bool           `json:"numbered"`
+	Frozen       bool           `json:"frozen"`
+	FrozenReason string         `json:"frozenReason,omitempty"`
+	Rate         *rateStatus    `json:"rate,omitempty"`
+	Cooldown     string         `json:"cooldown,omitempty"`
+	LastRelease  *time.Time     `json:"lastRelease,omitempty"`
+	Audit        []auditStatus  `json:"audit"`
+}
+
+func newSemaphoreStatus(path string, sem *lock.Semaphore, now time.Time) *semaphoreStatus {
+	status := &semaphoreStatus{
+		Path:         path,
+		Max:          sem.Max,
+		Available:    sem.Semaphore,
+		Holders:      []holderStatus{},
+		Waiters:      sem.Waiters,
+		Numbered:     sem.Numbered,
+		Frozen:       sem.Frozen,
+		FrozenReason: sem.FrozenReason,
+	}
+	if status.Waiters == nil {
+		status.Waiters = []string{}
+	}
+
+	for _, h := range sem.Holders {
+		hs := holderStatus{Name: h}
+		if info, ok := sem.HolderInfo[h]; ok {
+			started := info.Started()
+			held := now.Sub(started)
+			hs.Slot = info.Slot
+			hs.Acquired = &started
+			hs.Held = held.Truncate(time.Second).String()
+			hs.HeldSecs = held.Seconds()
+		}
+		status.Holders = append(status.Holders, hs)
+	}
+
+	if sem.Rate != nil {
+		inWindow := 0
+		start := now.Add(-sem.Rate.Window).UnixNano()
+		for _, g := range sem.Rate.Grants {
+			if g > start {
+				inWindow++
+			}
+		}
+		status.Rate = &rateStatus{sem.Rate.Limit, sem.Rate.Window.String(), inWindow}
+	}
+
+	if sem.Cooldown > 0 {
+		status.Cooldown = sem.Cooldown.String()
+	}
+	if sem.LastRelease != 0 {
+		t := time.Unix(0, sem.LastRelease)
+		status.LastRelease = &t
+	}
+
+	status.Audit = []auditStatus{}
+	for _, e := range sem.Audit {
+		status.Audit = append(status.Audit, auditStatus{
+			e.When(), e.Action, e.Actor, e.Holder, e.Reason, e.Detail,
+		})
+	}
+
+	return status
+}
+
+// table renders the status for humans.
+func (s *semaphoreStatus) table() string {
+	var b bytes.Buffer
+	w := tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+
+	frozen := "no"
+	if s.Frozen {
+		frozen = "yes"
+		if s.FrozenReason != "" {
+			frozen += " (" + s.FrozenReason + ")"
+		}
+	}
+
+	fmt.Fprintf(w, "Path:\t%s\n", s.Path)
+	fmt.Fprintf(w, "Max:\t%d\n", s.Max)
+	fmt.Fprintf(w, "Available:\t%d\n", s.Available)
+	fmt.Fprintf(w, "Frozen:\t%s\n", frozen)
+	if s.Rate != nil {
+		fmt.Fprintf(w, "Rate:\t%d per %s, %d in window\n", s.Rate.Limit, s.Rate.Window, s.Rate.InWindow)
+	}
+	if s.Cooldown != "" {
+		fmt.Fprintf(w, "Cooldown:\t%s\n", s.Cooldown)
+	}
+	if s.LastRelease != nil {
+		fmt.Fprintf(w, "Last release:\t%s\n", s.LastRelease.Format(time.RFC3339))
+	}
+	fmt.Fprintf(w, "Waiters:\t%s\n", strings.Join(s.Waiters, ", "))
+	w.Flush()
+
+	b.WriteString("\n")
+	if len(s.Holders) == 0 {
+		b.WriteString("No holders\n")
+	} else {
+		w = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "HOLDER\tSLOT\tACQUIRED\tHELD")
+		for _, h := range s.Holders {
+			slot, acquired, held := "-", "-", "-"
+			if h.Slot != nil {
+				slot = fmt.Sprint(*h.Slot)
+			}
+			if h.Acquired != nil {
+				acquired = h.Acquired.Format(time.RFC3339)
+				held = h.Held
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", h.Name, slot, acquired, held)
+		}
+		w.Flush()
+	}
+
+	if len(s.Audit) > 0 {
+		audit := s.Audit
+		if len(audit) > statusAuditLines {
+			audit = audit[len(audit)-statusAuditLines:]
+		}
+
+		b.WriteString("\n")
+		w = tabwriter.NewWriter(&b, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "CHANGED\tACTION\tBY\tDETAIL")
+		for _, e := range audit {
+			detail := strings.TrimSpace(strings.Join([]string{e.Holder, e.Detail}, " "))
+			if e.Reason != "" {
+				detail = strings.TrimSpace(detail + " (" + e.Reason + ")")
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Time.Format(time.RFC3339), e.Action, e.Actor, detail)
+		}
+		w.Flush()
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+func (c *StatusCommand) Run(args []string) int {
+	var format string
+	var consistent bool
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&format, "format", "table", "output format, table or json")
+		f.BoolVar(&consistent, "consistent", false, "read through the Consul leader")
+	})
+	if err != nil {
+		return 1
+	}
+
+	if format != "table" && format != "json" {
+		c.Ui.Error(fmt.Sprintf("Unknown -format: %s", format))
+		return 1
+	}
+
+	sem, err := c.inspect(parser, consistent)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	status := newSemaphoreStatus(parser.Path, sem, time.Now())
+	if format == "json" {
+		b, err := json.MarshalIndent(status, "", "  ")
+		if err != nil {
+			c.Ui.Error(fmt.Sprintf("Error encoding status: %s", err))
+			return 1
+		}
+		c.Ui.Output(string(b))
+		return 0
+	}
+
+	c.Ui.Output(status.table())
+	return 0
+}
+
+// inspect reads the semaphore, through the agent if one is running.
+func (c *StatusCommand) inspect(parser *Parser, consistent bool) (*lock.Semaphore, error) {
+	if ac := parser.agent(); ac != nil {
+		req := parser.agentRequest()
+		req.Consistent = consistent
+		return ac.Status(req)
+	}
+
+	client, err := parser.consulClient()
+	if err != nil {
+		return nil, err
+	}
+	return semaphore.Inspect(client, parser.Path, consistent)
+}
+
+func (c *StatusCommand) Synopsis() string {
+	return "Shows the state of a semaphore"
+}
+
+func (c *StatusCommand) Help() string {
+	helpText := `
+Usage consul-semaphore status [options]
+
+  Shows the state of a semaphore: its limits, holders and how long they
+  have held it, waiters, whether it is frozen, and recent changes from its
+  audit trail.
+
+Options:
+
+	-format                    Output format, table (default) or json
+	-consistent                Read through the Consul leader, rather than
+	                           accepting a possibly stale answer
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, commonHelp()))
+}
@@ -0,0 +1,114 @@
+package command
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+)
+
+func TestSemaphoreStatus(t *testing.T) {
+	// RFC 3339 times render the same wherever the test runs
+	local := time.Local
+	time.Local = time.UTC
+	defer func() { time.Local = local }()
+
+	at := func(sec int64) int64 { return time.Unix(sec, 0).UnixNano() }
+	slot := 0
+	audit := []lock.AuditEntry{}
+	for i := 0; i < 5; i++ {
+		audit = append(audit, lock.AuditEntry{Time: at(int64(1000 + i)), Action: "set-max", Actor: "admin", Detail: fmt.Sprintf("%d -> %d", i+1, i+2)})
+	}
+	audit = append(audit, lock.AuditEntry{Time: at(1005), Action: "evict", Actor: "admin", Holder: "d", Reason: "stuck"})
+
+	tests := []struct {
+		name  string
+		sem   lock.Semaphore
+		table []string
+		json  string
+	}{
+		{
+			name: "idle",
+			sem:  lock.Semaphore{Max: 1, Semaphore: 1},
+			table: []string{
+				"Path:       test/status",
+				"Max:        1",
+				"Available:  1",
+				"Frozen:     no",
+				"Waiters:    ",
+				"",
+				"No holders",
+			},
+			json: `{"path":"test/status","max":1,"available":1,"holders":[],"waiters":[],"numbered":false,"frozen":false,"audit":[]}`,
+		},
+		{
+			name: "busy",
+			sem: lock.Semaphore{
+				Max:          3,
+				Semaphore:    1,
+				Holders:      []string{"a", "b"},
+				Waiters:      []string{"c"},
+				HolderInfo:   map[string]lock.Holder{"a": {StartTime: at(1900), Slot: &slot}},
+				Numbered:     true,
+				Frozen:       true,
+				FrozenReason: "deploy",
+				Rate:         &lock.Rate{Limit: 2, Window: time.Minute, Grants: []int64{at(1900), at(1990)}},
+				Cooldown:     30 * time.Second,
+				LastRelease:  at(1950),
+				Audit:        audit,
+			},
+			table: []string{
+				"Path:          test/status",
+				"Max:           3",
+				"Available:     1",
+				"Frozen:        yes (deploy)",
+				"Rate:          2 per 1m0s, 1 in window",
+				"Cooldown:      30s",
+				"Last release:  1970-01-01T00:32:30Z",
+				"Waiters:       c",
+				"",
+				"HOLDER  SLOT  ACQUIRED              HELD",
+				"a       0     1970-01-01T00:31:40Z  1m40s",
+				"b       -     -                     -",
+				"",
+				"CHANGED               ACTION   BY     DETAIL",
+				"1970-01-01T00:16:41Z  set-max  admin  2 -> 3",
+				"1970-01-01T00:16:42Z  set-max  admin  3 -> 4",
+				"1970-01-01T00:16:43Z  set-max  admin  4 -> 5",
+				"1970-01-01T00:16:44Z  set-max  admin  5 -> 6",
+				"1970-01-01T00:16:45Z  evict    admin  d (stuck)",
+			},
+			json: `{"path":"test/status","max":3,"available":1,` +
+				`"holders":[{"name":"a","slot":0,"acquired":"1970-01-01T00:31:40Z","held":"1m40s","heldSeconds":100},{"name":"b"}],` +
+				`"waiters":["c"],"numbered":true,"frozen":true,"frozenReason":"deploy",` +
+				`"rate":{"limit":2,"window":"1m0s","inWindow":1},"cooldown":"30s","lastRelease":"1970-01-01T00:32:30Z",` +
+				`"audit":[` +
+				`{"time":"1970-01-01T00:16:40Z","action":"set-max","actor":"admin","detail":"1 -\u003e 2"},` +
+				`{"time":"1970-01-01T00:16:41Z","action":"set-max","actor":"admin","detail":"2 -\u003e 3"},` +
+				`{"time":"1970-01-01T00:16:42Z","action":"set-max","actor":"admin","detail":"3 -\u003e 4"},` +
+				`{"time":"1970-01-01T00:16:43Z","action":"set-max","actor":"admin","detail":"4 -\u003e 5"},` +
+				`{"time":"1970-01-01T00:16:44Z","action":"set-max","actor":"admin","detail":"5 -\u003e 6"},` +
+				`{"time":"1970-01-01T00:16:45Z","action":"evict","actor":"admin","holder":"d","reason":"stuck"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		status := newSemaphoreStatus("test/status", &test.sem, time.Unix(2000, 0))
+
+		if got, want := status.table(), strings.Join(test.table, "\n"); got != want {
+			t.Errorf("%s: table\n%s\nwant\n%s", test.name, got, want)
+		}
+
+		b, err := json.Marshal(status)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(b) != test.json {
+			t.Errorf("%s: json\n%s\nwant\n%s", test.name, b, test.json)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package command
+
+import (
+	"io"
+	"os"
+	"time"
+)
+
+// isTerminal reports whether f is a character device such as a TTY.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// prefixWriter writes to w, starting every line with prefix().
+type prefixWriter struct {
+	w       io.Writer
+	prefix  func() string
+	midLine bool
+}
+
+func (pw *prefixWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		if !pw.midLine {
+			if _, err = io.WriteString(pw.w, pw.prefix()); err != nil {
+				return n, err
+			}
+			pw.midLine = true
+		}
+
+		line := p
+		for i, b := range p {
+			if b == '\n' {
+				line = p[:i+1]
+				pw.midLine = false
+				break
+			}
+		}
+
+		written, err := pw.w.Write(line)
+		n += written
+		if err != nil {
+			return n, err
+		}
+		p = p[len(line):]
+	}
+
+	return n, nil
+}
+
+// holderPrefix returns a prefix function stamping lines with the time and
+// holder.
+func holderPrefix(holder string) func() string {
+	return func() string {
+		return time.Now().Format(time.RFC3339) + " " + holder + ": "
+	}
+}
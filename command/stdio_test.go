@@ -0,0 +1,72 @@
+package command
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestPrefixWriter(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []string
+		want   string
+	}{
+		{"one line", []string{"a\n"}, "[1] a\n"},
+		{"several lines", []string{"a\nb\nc\n"}, "[1] a\n[2] b\n[3] c\n"},
+		{"partial lines", []string{"a", "b\nc", "d\n"}, "[1] ab\n[2] cd\n"},
+		// the next prefix waits for the next line's first byte, so it
+		// carries the time that line started
+		{"trailing newline", []string{"a\n", "b"}, "[1] a\n[2] b"},
+		{"blank lines", []string{"\n\n"}, "[1] \n[2] \n"},
+		{"empty write", []string{""}, ""},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		calls := 0
+		pw := &prefixWriter{w: &b, prefix: func() string {
+			calls++
+			return fmt.Sprintf("[%d] ", calls)
+		}}
+
+		for _, w := range test.writes {
+			if n, err := pw.Write([]byte(w)); n != len(w) || err != nil {
+				t.Errorf("%s: Write(%q) returned %d, %v", test.name, w, n, err)
+			}
+		}
+		if got := b.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+// failingWriter accepts limit bytes, then fails.
+type failingWriter struct {
+	bytes.Buffer
+	limit int
+}
+
+func (w *failingWriter) Write(p []byte) (int, error) {
+	if room := w.limit - w.Len(); len(p) > room {
+		w.Buffer.Write(p[:room])
+		return room, errors.New("disk full")
+	}
+	return w.Buffer.Write(p)
+}
+
+func TestPrefixWriterError(t *testing.T) {
+	w := &failingWriter{limit: 8}
+	pw := &prefixWriter{w: w, prefix: func() string { return "> " }}
+
+	// "> a\n" fits, and so do "> " and "bc" of the second line, but not
+	// its newline
+	n, err := pw.Write([]byte("a\nbc\n"))
+	if err == nil {
+		t.Fatal("no error from a failing writer")
+	}
+	if n != 4 {
+		t.Errorf("Write reported %d bytes written, want the 4 of p that were", n)
+	}
+}
@@ -0,0 +1,102 @@
+package command
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+)
+
+// WaitCommand blocks until a semaphore meets a condition.
+type WaitCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+func (c *WaitCommand) Run(args []string) int {
+	var (
+		until   string
+		timeout time.Duration
+	)
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&until, "until", "", "condition to wait for")
+		f.DurationVar(&timeout, "timeout", 0, "how long to wait")
+	})
+	if err != nil {
+		return 1
+	}
+
+	if until == "" {
+		c.Ui.Error("Error: -until is required")
+		return 1
+	}
+	cond, err := parseCondition(until)
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error: -until: %s", err))
+		return 1
+	}
+
+	sem, _, err := parser.existing()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore %s: %s", parser.Path, err))
+		return exitCode(err, ExitError)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	sig, err := interruptible(signals, func(ctx context.Context) error {
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+		_, err := sem.WaitFor(ctx, cond)
+		return err
+	})
+	if sig != nil {
+		c.Ui.Error(fmt.Sprintf("Received %v while waiting for %s, giving up", sig, until))
+		return signalExitCode(sig)
+	}
+	if err == context.DeadlineExceeded {
+		c.Ui.Error(fmt.Sprintf("Timed out after %v waiting for %s", timeout, until))
+		return ExitAcquireTimeout
+	}
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error waiting for semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	return 0
+}
+
+func (c *WaitCommand) Synopsis() string {
+	return "Waits until a semaphore meets a condition"
+}
+
+func (c *WaitCommand) Help() string {
+	helpText := `
+Usage consul-semaphore wait -until <condition> [options]
+
+  Waits until a semaphore meets a condition, such as every holder having
+  released it, watching it through Consul blocking queries.  Exits at once
+  if the condition already holds.  Fails if the semaphore does not exist,
+  rather than creating it.
+
+Options:
+
+	-until                     The condition to wait for, required:
+%s
+	-timeout                   Wait at most this long, e.g. 10m, then exit
+	                           with code 201
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, conditionHelp(), commonHelp()))
+}
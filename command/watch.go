@@ -0,0 +1,149 @@
+package command
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/mitchellh/cli"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// WatchCommand streams changes to a semaphore as JSON lines.
+type WatchCommand struct {
+	Ui   cli.Ui
+	Name string
+}
+
+// countsLine is a semaphore's state as shown on a watch line.
+type countsLine struct {
+	Max       int `json:"max"`
+	Available int `json:"available"`
+	Holders   int `json:"holders"`
+	Waiters   int `json:"waiters"`
+}
+
+// eventLine is an event as printed by watch.
+type eventLine struct {
+	Time   time.Time           `json:"time"`
+	Path   string              `json:"path"`
+	Event  semaphore.EventType `json:"event"`
+	Holder string              `json:"holder,omitempty"`
+	Before *countsLine         `json:"before"`
+	After  *countsLine         `json:"after"`
+}
+
+func newCountsLine(sem *lock.Semaphore) *countsLine {
+	if sem == nil {
+		return nil
+	}
+	return &countsLine{sem.Max, sem.Semaphore, len(sem.Holders), len(sem.Waiters)}
+}
+
+func (c *WatchCommand) Run(args []string) int {
+	var until string
+	parser, err := newParser(c.Name, args, func(f *flag.FlagSet) {
+		f.StringVar(&until, "until", "", "exit once the semaphore meets this condition")
+	})
+	if err != nil {
+		return 1
+	}
+
+	var cond condition
+	if until != "" {
+		if cond, err = parseCondition(until); err != nil {
+			c.Ui.Error(fmt.Sprintf("Error: -until: %s", err))
+			return 1
+		}
+	}
+
+	sem, state, err := parser.existing()
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error reading semaphore %s: %s", parser.Path, err))
+		return exitCode(err, ExitError)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, forwardedSignals...)
+	defer signal.Stop(signals)
+
+	_, err = interruptible(signals, func(ctx context.Context) error {
+		if cond != nil && cond(state) {
+			return nil
+		}
+
+		events, err := sem.Subscribe(ctx)
+		if err != nil {
+			return err
+		}
+
+		for {
+			var e semaphore.Event
+			var ok bool
+			select {
+			case <-ctx.Done():
+				return nil
+			case e, ok = <-events:
+			}
+			if !ok {
+				return nil
+			}
+
+			b, err := json.Marshal(eventLine{
+				Time:   time.Now(),
+				Path:   sem.Path,
+				Event:  e.Type,
+				Holder: e.Holder,
+				Before: newCountsLine(e.Before),
+				After:  newCountsLine(e.After),
+			})
+			if err != nil {
+				return err
+			}
+			c.Ui.Output(string(b))
+
+			if e.Type == semaphore.Deleted || (cond != nil && cond(e.After)) {
+				return nil
+			}
+		}
+	})
+	if err != nil {
+		c.Ui.Error(fmt.Sprintf("Error watching semaphore: %s", err))
+		return exitCode(err, ExitError)
+	}
+
+	return 0
+}
+
+func (c *WatchCommand) Synopsis() string {
+	return "Streams changes to a semaphore as JSON lines"
+}
+
+func (c *WatchCommand) Help() string {
+	helpText := `
+Usage consul-semaphore watch [options]
+
+  Prints a line of JSON for each change to a semaphore: when it was seen,
+  the kind of event, the holder concerned, and the semaphore's counts
+  before and after.  Changes are seen through Consul blocking queries, and
+  several changes between two queries are reported as their net effect.
+
+  Runs until interrupted, the semaphore is deleted, or the -until condition
+  is met.  Fails if the semaphore does not exist, rather than creating it.
+
+Options:
+
+	-until                     Exit once the semaphore meets this condition,
+	                           without printing anything if it already does:
+%s
+%s
+	`
+
+	return strings.TrimSpace(fmt.Sprintf(helpText, conditionHelp(), commonHelp()))
+}
@@ -12,12 +12,20 @@ var Commands map[string]cli.CommandFactory
 
 func init() {
 	ui := &cli.BasicUi{
+		Reader:      os.Stdin,
 		Writer:      os.Stdout,
 		ErrorWriter: os.Stderr,
 	}
 
 	Commands = map[string]cli.CommandFactory{
 
+		"agent": func() (cli.Command, error) {
+			return &command.AgentCommand{
+				Ui:   ui,
+				Name: "agent",
+			}, nil
+		},
+
 		"init": func() (cli.Command, error) {
 			return &command.InitCommand{
 				Ui:   ui,
@@ -46,6 +54,90 @@ func init() {
 			}, nil
 		},
 
+		"env": func() (cli.Command, error) {
+			return &command.EnvCommand{
+				Ui:   ui,
+				Name: "env",
+			}, nil
+		},
+
+		"evict": func() (cli.Command, error) {
+			return &command.EvictCommand{
+				Ui:   ui,
+				Name: "evict",
+			}, nil
+		},
+
+		"freeze": func() (cli.Command, error) {
+			return &command.FreezeCommand{
+				Ui:   ui,
+				Name: "freeze",
+			}, nil
+		},
+
+		"unfreeze": func() (cli.Command, error) {
+			return &command.UnfreezeCommand{
+				Ui:   ui,
+				Name: "unfreeze",
+			}, nil
+		},
+
+		"list": func() (cli.Command, error) {
+			return &command.ListCommand{
+				Ui:   ui,
+				Name: "list",
+			}, nil
+		},
+
+		"reap": func() (cli.Command, error) {
+			return &command.ReapCommand{
+				Ui:   ui,
+				Name: "reap",
+			}, nil
+		},
+
+		"renew": func() (cli.Command, error) {
+			return &command.RenewCommand{
+				Ui:   ui,
+				Name: "renew",
+			}, nil
+		},
+
+		"serve": func() (cli.Command, error) {
+			return &command.ServeCommand{
+				Ui:   ui,
+				Name: "serve",
+			}, nil
+		},
+
+		"set-max": func() (cli.Command, error) {
+			return &command.SetMaxCommand{
+				Ui:   ui,
+				Name: "set-max",
+			}, nil
+		},
+
+		"status": func() (cli.Command, error) {
+			return &command.StatusCommand{
+				Ui:   ui,
+				Name: "status",
+			}, nil
+		},
+
+		"wait": func() (cli.Command, error) {
+			return &command.WaitCommand{
+				Ui:   ui,
+				Name: "wait",
+			}, nil
+		},
+
+		"watch": func() (cli.Command, error) {
+			return &command.WatchCommand{
+				Ui:   ui,
+				Name: "watch",
+			}, nil
+		},
+
 		"version": func() (cli.Command, error) {
 			ver := Version
 			rel := VersionPrerelease
@@ -0,0 +1,212 @@
+// Package health answers questions about Consul health checks that gate
+// semaphore operations, such as whether a restarted service is passing its
+// checks again, or whether the cluster is healthy enough to start another
+// restart.
+package health
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+const (
+	passing = "passing"
+
+	// maxWait caps each blocking query so cancellation is noticed.
+	maxWait = 30 * time.Second
+)
+
+// UnhealthyErr lists the checks that were not passing.
+type UnhealthyErr []string
+
+func (u UnhealthyErr) Error() string {
+	return fmt.Sprintf("checks not passing: %s", strings.Join(u, ", "))
+}
+
+// LocalNode returns the name of the Consul node the client's agent runs on.
+func LocalNode(client *api.Client) (string, error) {
+	self, err := client.Agent().Self()
+	if err != nil {
+		return "", err
+	}
+
+	name, ok := self["Config"]["NodeName"].(string)
+	if !ok || name == "" {
+		return "", errors.New("agent did not report its node name")
+	}
+
+	return name, nil
+}
+
+// Selector picks the checks on a node to wait for: the checks named in
+// Checks (by ID or name), plus every check of Service, if set.
+type Selector struct {
+	Checks  []string
+	Service string
+}
+
+// Empty reports whether the selector selects nothing.
+func (s Selector) Empty() bool {
+	return len(s.Checks) == 0 && s.Service == ""
+}
+
+// failing returns the selected checks that are not passing.  Named checks
+// that are missing count as failing, as does a service with no checks, so
+// a service that has not re-registered yet is not mistaken for healthy.
+func (s Selector) failing(checks []*api.HealthCheck) (failing UnhealthyErr) {
+	found := make(map[string]bool)
+	serviceFound := false
+
+	for _, c := range checks {
+		selected := false
+		for _, name := range s.Checks {
+			if c.CheckID == name || c.Name == name {
+				found[name] = true
+				selected = true
+			}
+		}
+		if s.Service != "" && c.ServiceName == s.Service {
+			serviceFound = true
+			selected = true
+		}
+
+		if selected && c.Status != passing {
+			failing = append(failing, fmt.Sprintf("%s (%s)", c.CheckID, c.Status))
+		}
+	}
+
+	for _, name := range s.Checks {
+		if !found[name] {
+			failing = append(failing, fmt.Sprintf("%s (missing)", name))
+		}
+	}
+	if s.Service != "" && !serviceFound {
+		failing = append(failing, fmt.Sprintf("service:%s (missing)", s.Service))
+	}
+
+	sort.Strings(failing)
+	return failing
+}
+
+// WaitPassing blocks until every check selected on node is passing, using
+// blocking queries against the health endpoint.  If ctx is done first, the
+// checks that were still failing are returned as an UnhealthyErr.
+//
+// Right after a service restarts, Consul still reports its checks as they
+// were before, until each runs again, so the checks are only looked at once
+// settle has passed.  It should be at least the checks' interval.
+func WaitPassing(ctx context.Context, client *api.Client, node string, sel Selector, settle time.Duration) error {
+	var (
+		index   uint64
+		failing UnhealthyErr
+	)
+
+	select {
+	case <-time.After(settle):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			if failing != nil {
+				return failing
+			}
+			return err
+		}
+
+		wait := maxWait
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+
+		qo := &api.QueryOptions{WaitIndex: index, WaitTime: wait}
+		checks, meta, err := client.Health().Node(node, qo)
+		if err != nil {
+			return err
+		}
+
+		failing = sel.failing(checks)
+		if len(failing) == 0 {
+			return nil
+		}
+
+		index = meta.LastIndex
+	}
+}
+
+// ServiceGate is a semaphore.Gate that stays closed while a service is
+// unhealthy across the cluster: while any instance has a check that isn't
+// passing or, if MinPassing is set, while fewer than MinPassing instances
+// are passing.  A service with no instances registered at all, as when its
+// name is mistyped, counts as unhealthy.
+type ServiceGate struct {
+	Client     *api.Client
+	Service    string
+	MinPassing int
+
+	index uint64
+}
+
+func (g *ServiceGate) Check() error {
+	entries, meta, err := g.Client.Health().Service(g.Service, "", false, nil)
+	if err != nil {
+		return err
+	}
+	g.index = meta.LastIndex
+
+	return g.judge(entries)
+}
+
+// judge decides whether the gate is closed given the service's instances.
+func (g *ServiceGate) judge(entries []*api.ServiceEntry) error {
+	var healthy, unhealthy []string
+	for _, e := range entries {
+		name := fmt.Sprintf("%s on %s", e.Service.ID, e.Node.Node)
+		if instancePassing(e) {
+			healthy = append(healthy, name)
+		} else {
+			unhealthy = append(unhealthy, name)
+		}
+	}
+
+	switch {
+	case len(entries) == 0:
+		return semaphore.GateClosedErr{Reason: fmt.Sprintf("no instances of %s registered", g.Service)}
+	case g.MinPassing > 0 && len(healthy) < g.MinPassing:
+		return semaphore.GateClosedErr{Reason: fmt.Sprintf(
+			"%d of %d required instances of %s passing", len(healthy), g.MinPassing, g.Service)}
+	case g.MinPassing == 0 && len(unhealthy) > 0:
+		return semaphore.GateClosedErr{Reason: fmt.Sprintf(
+			"%s failing: %s", g.Service, strings.Join(unhealthy, ", "))}
+	}
+
+	return nil
+}
+
+// Wait blocks until the service's health changes from the last Check.
+func (g *ServiceGate) Wait(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	qo := &api.QueryOptions{WaitIndex: g.index, WaitTime: maxWait}
+	_, _, err := g.Client.Health().Service(g.Service, "", false, qo)
+	return err
+}
+
+func instancePassing(e *api.ServiceEntry) bool {
+	for _, c := range e.Checks {
+		if c.Status != passing {
+			return false
+		}
+	}
+	return true
+}
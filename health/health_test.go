@@ -0,0 +1,138 @@
+package health
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+func TestSelectorFailing(t *testing.T) {
+	checks := []*api.HealthCheck{
+		{CheckID: "serfHealth", Name: "Serf Health Status", Status: "passing"},
+		{CheckID: "service:web", Name: "web check", Status: "critical", ServiceName: "web"},
+		{CheckID: "service:db", Name: "db check", Status: "passing", ServiceName: "db"},
+	}
+
+	tests := []struct {
+		sel  Selector
+		want UnhealthyErr
+	}{
+		{Selector{Checks: []string{"serfHealth"}}, nil},
+		{Selector{Checks: []string{"Serf Health Status"}, Service: "db"}, nil},
+		{Selector{Service: "web"}, UnhealthyErr{"service:web (critical)"}},
+		{Selector{Service: "cache"}, UnhealthyErr{"service:cache (missing)"}},
+		{Selector{Checks: []string{"disk", "serfHealth"}}, UnhealthyErr{"disk (missing)"}},
+	}
+
+	for _, test := range tests {
+		got := test.sel.failing(checks)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%+v: got %v, want %v", test.sel, got, test.want)
+		}
+	}
+}
+
+func TestReaperJudge(t *testing.T) {
+	nodes := map[string]bool{"web1": true, "web2": true, "web3": true}
+	failing := map[string]bool{"web2": true}
+	holders := []string{"web1", "web2.example.com", "ci-job-42"}
+
+	r := &Reaper{Grace: time.Minute}
+	start := time.Unix(1000, 0)
+
+	reap, unknown, recheck := r.judge(holders, nodes, failing, start)
+	if len(reap) != 0 {
+		t.Errorf("first pass reaped %v, want none", reap)
+	}
+	if len(unknown) != 1 || unknown[0] != "ci-job-42" {
+		t.Errorf("first pass unknown %v, want ci-job-42", unknown)
+	}
+	if recheck != time.Minute {
+		t.Errorf("first pass recheck %v, want %v", recheck, time.Minute)
+	}
+
+	reap, _, recheck = r.judge(holders, nodes, failing, start.Add(20*time.Second))
+	if reap["web2.example.com"] != "" {
+		t.Errorf("reaped web2 within grace: %v", reap)
+	}
+	if recheck != 40*time.Second {
+		t.Errorf("second pass recheck %v, want %v", recheck, 40*time.Second)
+	}
+
+	reap, _, recheck = r.judge(holders, nodes, failing, start.Add(time.Minute))
+	if len(reap) != 1 || reap["web2.example.com"] == "" {
+		t.Errorf("after grace reaped %v, want only web2", reap)
+	}
+	if recheck != 0 {
+		t.Errorf("after grace recheck %v, want 0", recheck)
+	}
+
+	// recovering resets the clock
+	r.judge(holders, nodes, nil, start.Add(2*time.Minute))
+	reap, _, _ = r.judge(holders, nodes, failing, start.Add(3*time.Minute))
+	if reap["web2.example.com"] != "" {
+		t.Errorf("reaped web2 right after it failed again: %v", reap)
+	}
+}
+
+func TestReaperJudgeUnmatched(t *testing.T) {
+	nodes := map[string]bool{"web1": true}
+	holders := []string{"web1", "gone"}
+
+	r := &Reaper{Grace: time.Minute, Unmatched: true}
+	start := time.Unix(1000, 0)
+
+	reap, unknown, recheck := r.judge(holders, nodes, nil, start)
+	if len(reap) != 0 || len(unknown) != 0 {
+		t.Errorf("first pass reaped %v and reported %v, want neither", reap, unknown)
+	}
+	if recheck != time.Minute {
+		t.Errorf("first pass recheck %v, want %v", recheck, time.Minute)
+	}
+
+	reap, _, _ = r.judge(holders, nodes, nil, start.Add(time.Minute))
+	if len(reap) != 1 || reap["gone"] == "" {
+		t.Errorf("after grace reaped %v, want only gone", reap)
+	}
+
+	// matching a node again resets the clock
+	r.judge(holders, map[string]bool{"web1": true, "gone": true}, nil, start.Add(2*time.Minute))
+	reap, _, _ = r.judge(holders, nodes, nil, start.Add(3*time.Minute))
+	if len(reap) != 0 {
+		t.Errorf("reaped gone right after it was unmatched again: %v", reap)
+	}
+}
+
+func TestServiceGateJudge(t *testing.T) {
+	entry := func(node, status string) *api.ServiceEntry {
+		return &api.ServiceEntry{
+			Node:    &api.Node{Node: node},
+			Service: &api.AgentService{ID: "web"},
+			Checks:  []*api.HealthCheck{{Status: status}},
+		}
+	}
+	one := []*api.ServiceEntry{entry("web1", passing), entry("web2", "critical")}
+	tests := []struct {
+		entries    []*api.ServiceEntry
+		minPassing int
+		open       bool
+	}{
+		{nil, 0, false},
+		{nil, 1, false},
+		{[]*api.ServiceEntry{entry("web1", passing)}, 0, true},
+		{one, 0, false},
+		{one, 1, true},
+		{one, 2, false},
+	}
+
+	for i, test := range tests {
+		g := &ServiceGate{Service: "web", MinPassing: test.minPassing}
+		err := g.judge(test.entries)
+		if _, closed := err.(semaphore.GateClosedErr); closed == test.open || (err != nil && !closed) {
+			t.Errorf("%d: got %v, want open %v", i, err, test.open)
+		}
+	}
+}
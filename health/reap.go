@@ -0,0 +1,147 @@
+package health
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	api "github.com/armon/consul-api"
+)
+
+const (
+	critical = "critical"
+
+	// serfHealth is the check Consul uses for a node's cluster membership.
+	serfHealth = "serfHealth"
+)
+
+// Reaper finds semaphore holders whose Consul node's serfHealth check has
+// been critical for longer than Grace.  Holders are matched to nodes by
+// name, as they default to the hostname; a holder named by a fully
+// qualified hostname also matches the node named by its first label.
+//
+// Holders that match no node in the catalog, whether their node has left or
+// they were given a name of their own, are only reported as unknown, unless
+// Unmatched is set, when they are reaped once unmatched for longer than
+// Grace too.
+//
+// How long a node has been critical, or a holder unmatched, is measured
+// from the first Check that saw it so, so a Reaper should be kept for
+// repeated Checks.
+type Reaper struct {
+	Client    *api.Client
+	Grace     time.Duration
+	Unmatched bool
+
+	// criticalSince is when each failing node was first seen critical, and
+	// unmatchedSince when each unmatched holder was first seen.
+	criticalSince  map[string]time.Time
+	unmatchedSince map[string]time.Time
+}
+
+// Check returns the holders to reap, each with the reason why, and the
+// holders that match no node and are not being reaped.  If some holders are
+// still within Grace, recheck is how long until the first of them runs out.
+func (r *Reaper) Check(holders []string) (reap map[string]string, unknown []string, recheck time.Duration, err error) {
+	catalog, _, err := r.Client.Catalog().Nodes(nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	nodes := make(map[string]bool, len(catalog))
+	for _, n := range catalog {
+		nodes[n.Node] = true
+	}
+
+	checks, _, err := r.Client.Health().State(critical, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	failing := make(map[string]bool)
+	for _, c := range checks {
+		if c.CheckID == serfHealth {
+			failing[c.Node] = true
+		}
+	}
+
+	reap, unknown, recheck = r.judge(holders, nodes, failing, time.Now())
+	return reap, unknown, recheck, nil
+}
+
+// since records now as when each of keys was first seen, forgetting those
+// no longer among keys.
+func since(seen map[string]time.Time, keys map[string]bool, now time.Time) {
+	for k := range seen {
+		if !keys[k] {
+			delete(seen, k)
+		}
+	}
+	for k := range keys {
+		if _, ok := seen[k]; !ok {
+			seen[k] = now
+		}
+	}
+}
+
+// judge decides which holders to reap given the nodes in the catalog and
+// those with a failing serfHealth check.
+func (r *Reaper) judge(holders []string, nodes, failing map[string]bool, now time.Time) (reap map[string]string, unknown []string, recheck time.Duration) {
+	if r.criticalSince == nil {
+		r.criticalSince = make(map[string]time.Time)
+		r.unmatchedSince = make(map[string]time.Time)
+	}
+
+	unmatched := make(map[string]bool)
+	for _, h := range holders {
+		if _, ok := nodeOf(h, nodes); !ok {
+			unmatched[h] = true
+		}
+	}
+	since(r.criticalSince, failing, now)
+	since(r.unmatchedSince, unmatched, now)
+
+	// wait returns whether the grace since start has run out, noting when
+	// to check again if not.
+	wait := func(start time.Time) (time.Duration, bool) {
+		down := now.Sub(start)
+		if down >= r.Grace {
+			return down.Truncate(time.Second), true
+		}
+		if left := r.Grace - down; recheck == 0 || left < recheck {
+			recheck = left
+		}
+		return 0, false
+	}
+
+	reap = make(map[string]string)
+	for _, h := range holders {
+		if unmatched[h] {
+			if !r.Unmatched {
+				unknown = append(unknown, h)
+			} else if down, ok := wait(r.unmatchedSince[h]); ok {
+				reap[h] = fmt.Sprintf("holder %s has matched no node in the Consul catalog for %v", h, down)
+			}
+			continue
+		}
+
+		node, _ := nodeOf(h, nodes)
+		if !failing[node] {
+			continue
+		}
+		if down, ok := wait(r.criticalSince[node]); ok {
+			reap[h] = fmt.Sprintf("node %s has been failing %s for %v", node, serfHealth, down)
+		}
+	}
+
+	return reap, unknown, recheck
+}
+
+// nodeOf returns the catalog node holder runs on.
+func nodeOf(holder string, nodes map[string]bool) (string, bool) {
+	if nodes[holder] {
+		return holder, true
+	}
+	if i := strings.Index(holder, "."); i > 0 && nodes[holder[:i]] {
+		return holder[:i], true
+	}
+	return "", false
+}
@@ -8,12 +8,14 @@ package lock
 import (
 	"encoding/json"
 	"errors"
+	"strings"
 
 	api "github.com/armon/consul-api"
 )
 
 var (
 	CheckAndSetFailedErr = errors.New("Someone else modified the semaphore")
+	SemaphoreNotFoundErr = errors.New("Semaphore does not exist")
 )
 
 // ConsulLockClient is a wrapper around the consul-api client
@@ -64,16 +66,30 @@ func (c *ConsulLockClient) Init() (err error) {
 }
 
 func (c *ConsulLockClient) Get() (sem *Semaphore, err error) {
-	kv := c.client.KV()
-	qo := &api.QueryOptions{
+	return c.get(&api.QueryOptions{
 		AllowStale:        false,
 		RequireConsistent: true,
-	}
+	})
+}
+
+// GetStale is Get, but allows any Consul server to answer, so the result
+// may be slightly out of date.  Use it for reads that aren't followed by a
+// Set.
+func (c *ConsulLockClient) GetStale() (sem *Semaphore, err error) {
+	return c.get(&api.QueryOptions{AllowStale: true})
+}
+
+func (c *ConsulLockClient) get(qo *api.QueryOptions) (sem *Semaphore, err error) {
+	kv := c.client.KV()
 	pair, _, err := kv.Get(c.Path, qo)
 	if err != nil {
 		return nil, err
 	}
 
+	if pair == nil {
+		return nil, SemaphoreNotFoundErr
+	}
+
 	sem = &Semaphore{}
 	err = json.Unmarshal([]byte(pair.Value), sem)
 	if err != nil {
@@ -132,12 +148,49 @@ func (c *ConsulLockClient) Watch(sem *Semaphore) (changed bool, err error) {
 
 	changed = meta.LastIndex != sem.Index
 
+	if pair == nil {
+		// the key was deleted out from under us
+		return true, SemaphoreNotFoundErr
+	}
+
 	// NOTE: modifies input argument..
-	err = json.Unmarshal([]byte(pair.Value), sem)
+	// Decode into a fresh value so omitted fields don't keep stale data.
+	fresh := Semaphore{}
+	err = json.Unmarshal([]byte(pair.Value), &fresh)
 	if err != nil {
 		return true, err
 	}
 
-	sem.Index = pair.ModifyIndex
+	fresh.Index = pair.ModifyIndex
+	*sem = fresh
 	return
 }
+
+// List reads every key under prefix in a single request, returning the
+// semaphores found keyed by path, and the paths of any other keys.  The read
+// may be slightly stale.
+func (c *ConsulLockClient) List(prefix string) (sems map[string]*Semaphore, invalid []string, err error) {
+	pairs, _, err := c.client.KV().List(prefix, &api.QueryOptions{AllowStale: true})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	sems = make(map[string]*Semaphore)
+	for _, pair := range pairs {
+		// skip "folders"
+		if strings.HasSuffix(pair.Key, "/") && len(pair.Value) == 0 {
+			continue
+		}
+
+		sem, err := ParseSemaphore(pair.Value)
+		if err != nil {
+			invalid = append(invalid, pair.Key)
+			continue
+		}
+
+		sem.Index = pair.ModifyIndex
+		sems[pair.Key] = sem
+	}
+
+	return sems, invalid, nil
+}
@@ -24,10 +24,19 @@
 
 package lock
 
+import (
+	"fmt"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/logging"
+)
+
 type Lock struct {
-	Path   string
-	id     string
-	client LockClient
+	Path    string
+	id      string
+	client  LockClient
+	log     logging.Logger
+	session string
 }
 
 func New(path string, id string, client LockClient) (lock *Lock, err error) {
@@ -36,8 +45,27 @@ func New(path string, id string, client LockClient) (lock *Lock, err error) {
 	if err != nil {
 		return nil, err
 	}
-	lock = &Lock{path, id, client}
-	return
+	return Open(path, id, client), nil
+}
+
+// Open is New without creating the semaphore, for only reading or watching
+// one that should already exist.  If it doesn't, reads fail with
+// SemaphoreNotFoundErr.
+func Open(path string, id string, client LockClient) *Lock {
+	client.SetPath(path)
+	lock := &Lock{Path: path, id: id, client: client}
+	lock.SetLogger(logging.Default())
+	return lock
+}
+
+// SetSession records the Consul session that later Locks last for.
+func (l *Lock) SetSession(session string) {
+	l.session = session
+}
+
+// SetLogger makes the lock log to l, with its path and id as fields.
+func (l *Lock) SetLogger(logger logging.Logger) {
+	l.log = logging.With(logger, logging.F("path", l.Path), logging.F("holder", l.id))
 }
 
 func (l *Lock) store(f func(*Semaphore) error) (err error) {
@@ -51,10 +79,16 @@ func (l *Lock) store(f func(*Semaphore) error) (err error) {
 	}
 
 	err = l.client.Set(sem)
+	if err == CheckAndSetFailedErr {
+		casConflicts.Inc(l.Path)
+		l.log.Log(logging.Debug, "check-and-set conflict", logging.F("index", sem.Index))
+	}
 	if err != nil {
 		return err
 	}
 
+	l.log.Log(logging.Debug, "semaphore updated", logging.F("index", sem.Index))
+	observe(l.Path, sem)
 	return nil
 }
 
@@ -64,39 +98,161 @@ func (l *Lock) Get() (sem *Semaphore, err error) {
 		return nil, err
 	}
 
+	observe(l.Path, sem)
 	return sem, nil
 }
 
 func (l *Lock) SetMax(max int) (sem *Semaphore, oldMax int, err error) {
+	return l.SetMaxReason(max, "")
+}
+
+// SetMaxReason is SetMax, recording the change and why it was made in the
+// semaphore's audit trail.
+func (l *Lock) SetMaxReason(max int, reason string) (sem *Semaphore, oldMax int, err error) {
 	var (
 		semRet *Semaphore
 		old    int
 	)
 
-	return semRet, old, l.store(func(sem *Semaphore) error {
+	// store has to run before semRet and old are read
+	err = l.store(func(sem *Semaphore) error {
 		old = sem.Max
 		semRet = sem
-		return sem.SetMax(max)
+		if err := sem.SetMax(max); err != nil {
+			return err
+		}
+		if old != max {
+			sem.AddAudit(AuditEntry{
+				Action: "set-max",
+				Actor:  l.id,
+				Reason: reason,
+				Detail: fmt.Sprintf("%d -> %d", old, max),
+			})
+		}
+		return nil
 	})
+	return semRet, old, err
 }
 
-func (l *Lock) Lock() (err error) {
+// SetRate limits the semaphore to limit acquisitions per window.  A limit of
+// zero removes the rate limit.
+func (l *Lock) SetRate(limit int, window time.Duration) error {
+	return l.store(func(sem *Semaphore) error {
+		return sem.SetRate(limit, window)
+	})
+}
+
+// SetCooldown sets the minimum time between an Unlock and the next Lock.
+func (l *Lock) SetCooldown(cooldown time.Duration) error {
+	return l.store(func(sem *Semaphore) error {
+		return sem.SetCooldown(cooldown)
+	})
+}
+
+func (l *Lock) Freeze(reason string) error {
+	return l.store(func(sem *Semaphore) error {
+		sem.AddAudit(AuditEntry{Action: "freeze", Actor: l.id, Reason: reason})
+		return sem.Freeze(reason)
+	})
+}
+
+func (l *Lock) Unfreeze() error {
+	return l.store(func(sem *Semaphore) error {
+		sem.AddAudit(AuditEntry{Action: "unfreeze", Actor: l.id})
+		return sem.Unfreeze()
+	})
+}
+
+// SetNumbered turns slot numbering on or off.
+func (l *Lock) SetNumbered(numbered bool) error {
 	return l.store(func(sem *Semaphore) error {
-		return sem.Lock(l.id)
+		return sem.SetNumbered(numbered)
 	})
 }
 
+func (l *Lock) Lock() (err error) {
+	_, err = l.LockSlot()
+	return err
+}
+
+// LockSlot is Lock, also returning the slot given to the lock's id, or -1 if
+// the semaphore's slots are not numbered.
+func (l *Lock) LockSlot() (slot int, err error) {
+	slot = -1
+	err = l.store(func(sem *Semaphore) error {
+		if err := sem.Lock(l.id); err != nil {
+			return err
+		}
+		if l.session != "" {
+			info := sem.HolderInfo[l.id]
+			info.Session = l.session
+			sem.HolderInfo[l.id] = info
+		}
+		slot = sem.Slot(l.id)
+		return nil
+	})
+	return slot, err
+}
+
 func (l *Lock) Unlock() error {
 	return l.store(func(sem *Semaphore) error {
 		return sem.Unlock(l.id)
 	})
 }
 
+// Enqueue registers the lock's id as waiting on the semaphore.  Waiters are
+// informational only; they are removed again by a successful Lock.
+func (l *Lock) Enqueue() error {
+	return l.store(func(sem *Semaphore) error {
+		return sem.addWaiter(l.id)
+	})
+}
+
+// Dequeue removes the lock's id from the semaphore's waiters.
+func (l *Lock) Dequeue() error {
+	return l.store(func(sem *Semaphore) error {
+		return sem.removeWaiter(l.id)
+	})
+}
+
+// Evict removes holder from the semaphore on the lock's id's behalf.
+func (l *Lock) Evict(holder string, reason string) error {
+	return l.store(func(sem *Semaphore) error {
+		return sem.Evict(holder, l.id, reason)
+	})
+}
+
 func (l *Lock) Watch() (changed bool, err error) {
 	sem, err := l.client.Get()
 	if err != nil {
 		return false, err
 	}
 
-	return l.client.Watch(sem)
+	changed, err = l.client.Watch(sem)
+	if err == nil {
+		l.watched(changed, sem)
+	}
+	return changed, err
+}
+
+// WatchFrom blocks until the semaphore differs from prev, or the underlying
+// long-poll times out, and returns the state it woke up with.  Unlike Watch,
+// prev is left untouched.
+func (l *Lock) WatchFrom(prev *Semaphore) (sem *Semaphore, err error) {
+	sem = prev.Copy()
+	changed, err := l.client.Watch(sem)
+	if err != nil {
+		return nil, err
+	}
+	l.watched(changed, sem)
+
+	return sem, nil
+}
+
+// watched records a watch returning with sem.
+func (l *Lock) watched(changed bool, sem *Semaphore) {
+	watchWakeups.Inc(l.Path, fmt.Sprint(changed))
+	observe(l.Path, sem)
+	l.log.Log(logging.Debug, "watch returned",
+		logging.F("changed", changed), logging.F("index", sem.Index))
 }
@@ -0,0 +1,20 @@
+package lock
+
+import "github.com/ryanschneider/consul-semaphore/metrics"
+
+var (
+	casConflicts = metrics.NewCounter("consul_semaphore_cas_conflicts_total",
+		"Writes to a semaphore that lost a check-and-set race.", "path")
+	watchWakeups = metrics.NewCounter("consul_semaphore_watch_wakeups_total",
+		"Watches of a semaphore that returned, by whether it had changed.", "path", "changed")
+	holdersGauge = metrics.NewGauge("consul_semaphore_holders",
+		"Holders of a semaphore when last seen.", "path")
+	maxGauge = metrics.NewGauge("consul_semaphore_max",
+		"Maximum holders of a semaphore when last seen.", "path")
+)
+
+// observe records the state of the semaphore at path.
+func observe(path string, sem *Semaphore) {
+	holdersGauge.Set(float64(len(sem.Holders)), path)
+	maxGauge.Set(float64(sem.Max), path)
+}
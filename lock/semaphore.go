@@ -28,11 +28,16 @@ import (
 	"errors"
 	"fmt"
 	"sort"
+	"time"
 )
 
+// now is stubbed out by tests.
+var now = time.Now
+
 var (
 	ErrExist    = errors.New("holder exists")
 	ErrNotExist = errors.New("holder does not exist")
+	ErrFrozen   = errors.New("semaphore is frozen")
 )
 
 type SemaphoreExhaustedErr int
@@ -41,14 +46,98 @@ func (i SemaphoreExhaustedErr) Error() string {
 	return fmt.Sprintf("semaphore exhausted at count: %v", int(i))
 }
 
+// RateLimitedErr is returned when a Semaphore's rate limit has been reached.
+// Its value is how long until the next acquisition would be allowed.
+type RateLimitedErr time.Duration
+
+func (d RateLimitedErr) Error() string {
+	return fmt.Sprintf("semaphore rate limited, next grant in %v", time.Duration(d))
+}
+
+// RetryAfter is how long to wait before trying to lock again.
+func (d RateLimitedErr) RetryAfter() time.Duration {
+	return time.Duration(d)
+}
+
+// CooldownErr is returned when a Semaphore was released too recently to be
+// locked again.  Its value is how long until the cooldown ends.
+type CooldownErr time.Duration
+
+func (d CooldownErr) Error() string {
+	return fmt.Sprintf("semaphore cooling down for another %v", time.Duration(d))
+}
+
+// RetryAfter is how long to wait before trying to lock again.
+func (d CooldownErr) RetryAfter() time.Duration {
+	return time.Duration(d)
+}
+
+// Rate limits how many times a Semaphore may be locked within a sliding
+// window of time, in addition to the concurrency limit set by Max.
+type Rate struct {
+	Limit  int           `json:"limit"`
+	Window time.Duration `json:"window"`
+	Grants []int64       `json:"grants,omitempty"`
+}
+
+// allow prunes grants that have left the window and reports how long until
+// another grant is possible, 0 meaning now.
+func (r *Rate) allow(t time.Time) time.Duration {
+	start := t.Add(-r.Window).UnixNano()
+	for len(r.Grants) > 0 && r.Grants[0] <= start {
+		r.Grants = r.Grants[1:]
+	}
+
+	if len(r.Grants) < r.Limit {
+		return 0
+	}
+
+	// the oldest grant has to leave the window first
+	return time.Duration(r.Grants[len(r.Grants)-r.Limit] - start)
+}
+
 type Semaphore struct {
 	Index     uint64   `json:"-"`
 	Semaphore int      `json:"semaphore"`
 	Max       int      `json:"max"`
 	Holders   []string `json:"holders"`
+	Waiters   []string `json:"waiters,omitempty"`
+
+	// HolderInfo is keyed by holder.  Holders that locked before it was
+	// recorded have no entry.
+	HolderInfo map[string]Holder `json:"holderInfo,omitempty"`
+
+	// Numbered semaphores give each holder the lowest free slot in
+	// [0, Max), which it keeps until it unlocks.
+	Numbered bool `json:"numbered,omitempty"`
+
+	Rate *Rate `json:"rate,omitempty"`
+
+	// Cooldown is the minimum time between a release and the next lock.
+	Cooldown    time.Duration `json:"cooldown,omitempty"`
+	LastRelease int64         `json:"lastRelease,omitempty"`
+
+	// A frozen semaphore refuses all locks until it is unfrozen.
+	Frozen       bool   `json:"frozen,omitempty"`
+	FrozenReason string `json:"frozenReason,omitempty"`
+
+	// Audit is the most recent administrative changes, oldest first.
+	Audit []AuditEntry `json:"audit,omitempty"`
+
+	// History is the most recent locks and unlocks, oldest first, so that
+	// watchers can tell what happened between two reads.
+	History []HistoryEntry `json:"history,omitempty"`
+
+	// Revoked records, by holder, evictions the holder has not yet seen
+	// by locking again.
+	Revoked map[string]AuditEntry `json:"revoked,omitempty"`
 }
 
 func (s *Semaphore) SetMax(max int) error {
+	if max < 0 {
+		return fmt.Errorf("invalid max: %v", max)
+	}
+
 	diff := s.Max - max
 
 	s.Semaphore = s.Semaphore - diff
@@ -57,6 +146,178 @@ func (s *Semaphore) SetMax(max int) error {
 	return nil
 }
 
+// Copy returns a deep copy of the semaphore, so that callers can keep a
+// snapshot while the original is modified in place.
+func (s *Semaphore) Copy() *Semaphore {
+	c := *s
+	if s.Holders != nil {
+		c.Holders = append([]string{}, s.Holders...)
+	}
+	if s.Waiters != nil {
+		c.Waiters = append([]string{}, s.Waiters...)
+	}
+	if s.HolderInfo != nil {
+		c.HolderInfo = make(map[string]Holder, len(s.HolderInfo))
+		for h, info := range s.HolderInfo {
+			c.HolderInfo[h] = info
+		}
+	}
+	if s.Audit != nil {
+		c.Audit = append([]AuditEntry{}, s.Audit...)
+	}
+	if s.History != nil {
+		c.History = append([]HistoryEntry{}, s.History...)
+	}
+	if s.Revoked != nil {
+		c.Revoked = make(map[string]AuditEntry, len(s.Revoked))
+		for h, e := range s.Revoked {
+			c.Revoked[h] = e
+		}
+	}
+	if s.Rate != nil {
+		r := *s.Rate
+		r.Grants = append([]int64{}, s.Rate.Grants...)
+		c.Rate = &r
+	}
+	return &c
+}
+
+// SetRate limits the semaphore to limit acquisitions per window.  A limit of
+// zero removes the rate limit.
+func (s *Semaphore) SetRate(limit int, window time.Duration) error {
+	if limit < 0 || (limit > 0 && window <= 0) {
+		return fmt.Errorf("invalid rate: %v per %v", limit, window)
+	}
+
+	if limit == 0 {
+		s.Rate = nil
+		return nil
+	}
+
+	var grants []int64
+	if s.Rate != nil {
+		grants = s.Rate.Grants
+	}
+	s.Rate = &Rate{Limit: limit, Window: window, Grants: grants}
+	return nil
+}
+
+// SetCooldown sets the minimum time between an Unlock and the next Lock.
+func (s *Semaphore) SetCooldown(cooldown time.Duration) error {
+	if cooldown < 0 {
+		return fmt.Errorf("invalid cooldown: %v", cooldown)
+	}
+
+	s.Cooldown = cooldown
+	return nil
+}
+
+// Freeze stops the semaphore from being locked until Unfreeze is called.
+// Current holders keep their locks and may still unlock.
+func (s *Semaphore) Freeze(reason string) error {
+	s.Frozen = true
+	s.FrozenReason = reason
+	return nil
+}
+
+func (s *Semaphore) Unfreeze() error {
+	s.Frozen = false
+	s.FrozenReason = ""
+	return nil
+}
+
+// AddAudit appends e, stamped with the current time, to the audit trail,
+// dropping the oldest entries beyond auditLimit.
+func (s *Semaphore) AddAudit(e AuditEntry) {
+	e.Time = now().UnixNano()
+	s.Audit = append(s.Audit, e)
+	if len(s.Audit) > auditLimit {
+		s.Audit = append([]AuditEntry{}, s.Audit[len(s.Audit)-auditLimit:]...)
+	}
+}
+
+// addHistory records that h locked or unlocked, numbering the entry one past
+// the last and dropping the oldest entries beyond historyLimit.
+func (s *Semaphore) addHistory(action string, h string) {
+	e := HistoryEntry{Seq: s.HistorySeq() + 1, Time: now().UnixNano(), Action: action, Holder: h}
+	s.History = append(s.History, e)
+	if len(s.History) > historyLimit {
+		s.History = append([]HistoryEntry{}, s.History[len(s.History)-historyLimit:]...)
+	}
+}
+
+// HistorySeq returns the Seq of the latest HistoryEntry, or 0 if there are
+// none.
+func (s *Semaphore) HistorySeq() uint64 {
+	if len(s.History) == 0 {
+		return 0
+	}
+	return s.History[len(s.History)-1].Seq
+}
+
+// SetNumbered turns slot numbering on or off.  Turning it on gives current
+// holders slots.
+func (s *Semaphore) SetNumbered(numbered bool) error {
+	s.Numbered = numbered
+
+	for _, h := range s.Holders {
+		info, ok := s.HolderInfo[h]
+		switch {
+		case numbered && info.Slot == nil:
+			slot := s.freeSlot()
+			info.ID = h
+			info.Slot = &slot
+		case !numbered && ok:
+			info.Slot = nil
+		default:
+			continue
+		}
+
+		if s.HolderInfo == nil {
+			s.HolderInfo = make(map[string]Holder)
+		}
+		s.HolderInfo[h] = info
+	}
+
+	return nil
+}
+
+// Slot returns h's slot, or -1 if h has none.
+func (s *Semaphore) Slot(h string) int {
+	if info, ok := s.HolderInfo[h]; ok && info.Slot != nil {
+		return *info.Slot
+	}
+	return -1
+}
+
+// SlotsOver returns the holders whose slots are max or more, such as after
+// lowering the max, keyed by holder.
+func (s *Semaphore) SlotsOver(max int) map[string]int {
+	over := make(map[string]int)
+	for h, info := range s.HolderInfo {
+		if info.Slot != nil && *info.Slot >= max {
+			over[h] = *info.Slot
+		}
+	}
+	return over
+}
+
+// freeSlot returns the lowest slot no holder has.
+func (s *Semaphore) freeSlot() int {
+	used := make(map[int]bool)
+	for _, info := range s.HolderInfo {
+		if info.Slot != nil {
+			used[*info.Slot] = true
+		}
+	}
+
+	slot := 0
+	for used[slot] {
+		slot++
+	}
+	return slot
+}
+
 func (s *Semaphore) String() string {
 	b, _ := json.Marshal(s)
 	return string(b)
@@ -96,7 +357,37 @@ func (s *Semaphore) findHolder(h string) (found bool) {
 	}
 }
 
+// addWaiter appends h to the end of the waiter queue.
+func (s *Semaphore) addWaiter(h string) error {
+	for _, w := range s.Waiters {
+		if w == h {
+			return ErrExist
+		}
+	}
+
+	s.Waiters = append(s.Waiters, h)
+	return nil
+}
+
+func (s *Semaphore) removeWaiter(h string) error {
+	for i, w := range s.Waiters {
+		if w == h {
+			s.Waiters = append(s.Waiters[:i], s.Waiters[i+1:]...)
+			if len(s.Waiters) == 0 {
+				s.Waiters = nil
+			}
+			return nil
+		}
+	}
+
+	return ErrNotExist
+}
+
 func (s *Semaphore) Lock(h string) error {
+	if s.Frozen {
+		return ErrFrozen
+	}
+
 	if s.Semaphore <= 0 {
 		if s.findHolder(h) {
 			// we consider re-acuring a lock for the same holder
@@ -106,12 +397,62 @@ func (s *Semaphore) Lock(h string) error {
 		return SemaphoreExhaustedErr(s.Semaphore)
 	}
 
+	t := now()
+	if s.Cooldown > 0 && s.LastRelease != 0 {
+		if wait := time.Unix(0, s.LastRelease).Add(s.Cooldown).Sub(t); wait > 0 {
+			return CooldownErr(wait)
+		}
+	}
+
+	if s.Rate != nil {
+		if wait := s.Rate.allow(t); wait > 0 {
+			return RateLimitedErr(wait)
+		}
+	}
+
 	if err := s.addHolder(h); err != nil {
 		return err
 	}
 
+	if s.Rate != nil {
+		s.Rate.Grants = append(s.Rate.Grants, t.UnixNano())
+	}
+
+	info := Holder{ID: h, StartTime: t.UnixNano()}
+	if s.Numbered {
+		slot := s.freeSlot()
+		info.Slot = &slot
+	}
+
+	if s.HolderInfo == nil {
+		s.HolderInfo = make(map[string]Holder)
+	}
+	s.HolderInfo[h] = info
+	delete(s.Revoked, h)
+	s.addHistory("lock", h)
+
 	s.Semaphore = s.Semaphore - 1
 
+	// a successful lock means h is no longer waiting
+	s.removeWaiter(h)
+
+	return nil
+}
+
+// Evict unlocks h on someone else's behalf, recording who did it and why in
+// the audit trail, and leaving a revocation marker for h to find.
+func (s *Semaphore) Evict(h string, actor string, reason string) error {
+	if err := s.Unlock(h); err != nil {
+		return err
+	}
+
+	e := AuditEntry{Action: "evict", Actor: actor, Holder: h, Reason: reason}
+	s.AddAudit(e)
+	if s.Revoked == nil {
+		s.Revoked = make(map[string]AuditEntry)
+	}
+	s.Revoked[h] = s.Audit[len(s.Audit)-1]
+
 	return nil
 }
 
@@ -119,17 +460,82 @@ func (s *Semaphore) Unlock(h string) error {
 	if err := s.removeHolder(h); err != nil {
 		return err
 	}
+	delete(s.HolderInfo, h)
+	s.addHistory("unlock", h)
 
 	s.Semaphore = s.Semaphore + 1
+	s.LastRelease = now().UnixNano()
 
 	return nil
 }
 
+// ParseSemaphore decodes a semaphore document, failing if b is not one.
+func ParseSemaphore(b []byte) (*Semaphore, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(b, &fields); err != nil {
+		return nil, fmt.Errorf("not a semaphore: %s", err)
+	}
+	for _, required := range []string{"semaphore", "max", "holders"} {
+		if _, ok := fields[required]; !ok {
+			return nil, fmt.Errorf("not a semaphore: missing %q", required)
+		}
+	}
+
+	sem := &Semaphore{}
+	if err := json.Unmarshal(b, sem); err != nil {
+		return nil, fmt.Errorf("not a semaphore: %s", err)
+	}
+	return sem, nil
+}
+
 func newSemaphore() (sem *Semaphore) {
-	return &Semaphore{0, 1, 1, nil}
+	return &Semaphore{Semaphore: 1, Max: 1}
+}
+
+// auditLimit is how many AuditEntries a Semaphore keeps.
+const auditLimit = 50
+
+// AuditEntry records an administrative change to a Semaphore.
+type AuditEntry struct {
+	Time   int64  `json:"time"`
+	Action string `json:"action"`
+	Actor  string `json:"actor"`
+	Holder string `json:"holder,omitempty"`
+	Reason string `json:"reason,omitempty"`
+	Detail string `json:"detail,omitempty"`
 }
 
-type holder struct {
+// When returns the time of the entry.
+func (e AuditEntry) When() time.Time {
+	return time.Unix(0, e.Time)
+}
+
+// historyLimit is how many HistoryEntries a Semaphore keeps.
+const historyLimit = 50
+
+// HistoryEntry records a holder locking or unlocking a Semaphore.  Seq
+// counts up from 1, so a gap shows entries have been dropped.
+type HistoryEntry struct {
+	Seq    uint64 `json:"seq"`
+	Time   int64  `json:"time"`
+	Action string `json:"action"`
+	Holder string `json:"holder"`
+}
+
+// Holder is what a Semaphore records about each of its holders.
+type Holder struct {
 	ID        string `json:"-"`
 	StartTime int64  `json:"startTime"`
+
+	// Slot is set when the semaphore numbers its slots.
+	Slot *int `json:"slot,omitempty"`
+
+	// Session is the Consul session the hold lasts for, if any.  Once the
+	// session has expired, the holder may be evicted.
+	Session string `json:"session,omitempty"`
+}
+
+// Started returns when the holder locked the semaphore.
+func (h Holder) Started() time.Time {
+	return time.Unix(0, h.StartTime)
 }
@@ -27,12 +27,13 @@ package lock
 import (
 	"reflect"
 	"testing"
+	"time"
 )
 
 type testLockClient struct {
 	path    string
 	sem     *Semaphore
-	holders []holder
+	holders []Holder
 }
 
 func (c *testLockClient) Init() (err error) {
@@ -301,3 +302,399 @@ func TestSetMax(t *testing.T) {
 		}
 	}
 }
+
+func TestWaiters(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	bl, err := New("path", "b", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := bl.Enqueue(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.Enqueue(); err != ErrExist {
+		t.Error("Enqueueing twice should have failed", err)
+	}
+	if !reflect.DeepEqual(c.sem.Waiters, []string{"b"}) {
+		t.Error("Enqueue did not add b to the waiters", c.sem.Waiters)
+	}
+
+	al.Unlock()
+	if err := bl.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.sem.Waiters) != 0 {
+		t.Error("Lock did not remove b from the waiters", c.sem.Waiters)
+	}
+
+	if err := bl.Dequeue(); err != ErrNotExist {
+		t.Error("Dequeueing a non-waiter should have failed", err)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	bl, err := New("path", "b", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cl, err := New("path", "c", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	start := time.Unix(1000, 0)
+	clock := start
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	al.SetMax(10)
+	if err := al.SetRate(2, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, l := range []*Lock{al, bl} {
+		if err := l.Lock(); err != nil {
+			t.Fatal(err)
+		}
+		clock = clock.Add(10 * time.Second)
+	}
+
+	err = cl.Lock()
+	if d, ok := err.(RateLimitedErr); !ok || d.RetryAfter() != 40*time.Second {
+		t.Fatal("Third lock within the window should have been rate limited", err)
+	}
+
+	// releasing does not give back a grant
+	al.Unlock()
+	if err := cl.Lock(); err == nil {
+		t.Fatal("Unlock should not reset the rate limit")
+	}
+
+	clock = start.Add(time.Minute)
+	if err := cl.Lock(); err != nil {
+		t.Fatal("Lock after the window should have succeeded", err)
+	}
+	if len(c.sem.Rate.Grants) != 2 {
+		t.Error("Expired grants were not pruned", c.sem.Rate.Grants)
+	}
+
+	if err := al.SetRate(0, 0); err != nil || c.sem.Rate != nil {
+		t.Error("SetRate(0) did not remove the rate limit", err)
+	}
+}
+
+func TestCooldown(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	if err := al.SetCooldown(2 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first lock has nothing to cool down from
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := al.Unlock(); err != nil {
+		t.Fatal(err)
+	}
+
+	clock = clock.Add(30 * time.Second)
+	err = al.Lock()
+	if d, ok := err.(CooldownErr); !ok || d.RetryAfter() != 90*time.Second {
+		t.Fatal("Lock during the cooldown should have been refused", err)
+	}
+
+	clock = clock.Add(90 * time.Second)
+	if err := al.Lock(); err != nil {
+		t.Fatal("Lock after the cooldown should have succeeded", err)
+	}
+}
+
+func TestFreeze(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	bl, err := New("path", "b", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	al.SetMax(2)
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := al.Freeze("testing"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.Lock(); err != ErrFrozen {
+		t.Error("Lock on a frozen semaphore should have failed", err)
+	}
+	if err := al.Unlock(); err != nil {
+		t.Error("Unlock on a frozen semaphore should have succeeded", err)
+	}
+
+	if err := al.Unfreeze(); err != nil {
+		t.Fatal(err)
+	}
+	if err := bl.Lock(); err != nil {
+		t.Error("Lock after unfreezing should have succeeded", err)
+	}
+}
+
+func TestHolderInfo(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if info, ok := c.sem.HolderInfo["a"]; !ok || !info.Started().Equal(clock) {
+		t.Error("Lock did not record when a started holding", c.sem.HolderInfo)
+	}
+
+	copied := c.sem.Copy()
+	al.Unlock()
+	if _, ok := c.sem.HolderInfo["a"]; ok {
+		t.Error("Unlock did not remove a's info")
+	}
+	if _, ok := copied.HolderInfo["a"]; !ok {
+		t.Error("Copy shares its holder info with the original")
+	}
+}
+
+func TestHistory(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	clock := time.Unix(1000, 0)
+	now = func() time.Time { return clock }
+	defer func() { now = time.Now }()
+
+	al.Lock()
+	al.Unlock()
+	want := []HistoryEntry{
+		{Seq: 1, Time: clock.UnixNano(), Action: "lock", Holder: "a"},
+		{Seq: 2, Time: clock.UnixNano(), Action: "unlock", Holder: "a"},
+	}
+	if !reflect.DeepEqual(c.sem.History, want) {
+		t.Error("Lock and Unlock were not recorded", c.sem.History)
+	}
+
+	copied := c.sem.Copy()
+	for i := 0; i < historyLimit; i++ {
+		al.Lock()
+		al.Unlock()
+	}
+	if len(c.sem.History) != historyLimit {
+		t.Error("History was not trimmed", len(c.sem.History))
+	}
+	if seq := c.sem.HistorySeq(); seq != 2+2*historyLimit {
+		t.Error("History was not numbered on from the last entry", seq)
+	}
+	if len(copied.History) != 2 {
+		t.Error("Copy shares its history with the original")
+	}
+}
+
+func TestNumberedSlots(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	bl, err := New("path", "b", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	cl, err := New("path", "c", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	al.SetMax(3)
+	if slot, err := cl.LockSlot(); err != nil || slot != -1 {
+		t.Fatal("Unnumbered semaphore should not give out slots", slot, err)
+	}
+
+	// numbering gives existing holders slots
+	if err := al.SetNumbered(true); err != nil {
+		t.Fatal(err)
+	}
+	if c.sem.Slot("c") != 0 {
+		t.Error("SetNumbered did not give c a slot", c.sem.HolderInfo)
+	}
+
+	if slot, err := al.LockSlot(); err != nil || slot != 1 {
+		t.Fatal("a should have been given slot 1", slot, err)
+	}
+	if slot, err := bl.LockSlot(); err != nil || slot != 2 {
+		t.Fatal("b should have been given slot 2", slot, err)
+	}
+
+	// freed slots are reused lowest first, others keep theirs
+	cl.Unlock()
+	al.Unlock()
+	if slot, err := al.LockSlot(); err != nil || slot != 0 {
+		t.Fatal("a should have been given slot 0", slot, err)
+	}
+	if c.sem.Slot("b") != 2 {
+		t.Error("b lost its slot", c.sem.HolderInfo)
+	}
+
+	sem, _, _ := al.SetMax(2)
+	if !reflect.DeepEqual(sem.SlotsOver(2), map[string]int{"b": 2}) {
+		t.Error("Lowering max did not report b's slot as over", sem.SlotsOver(2))
+	}
+}
+
+func TestParseSemaphore(t *testing.T) {
+	sem, err := ParseSemaphore([]byte(newSemaphore().String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(sem, newSemaphore()) {
+		t.Error("ParseSemaphore did not round trip", sem)
+	}
+
+	for _, b := range []string{`"text"`, `{"max": 1}`, `{"semaphore": "x", "max": 1, "holders": []}`, `not json`} {
+		if _, err := ParseSemaphore([]byte(b)); err == nil {
+			t.Error("ParseSemaphore accepted", b)
+		}
+	}
+}
+
+func TestSetMaxValidatesAndAudits(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if _, _, err := al.SetMax(-1); err == nil {
+		t.Error("SetMax should have refused a negative max")
+	}
+	if c.sem.Max != 1 || c.sem.Semaphore != 1 {
+		t.Error("Failed SetMax changed the semaphore", c.sem)
+	}
+
+	if _, old, err := al.SetMaxReason(3, "more capacity"); err != nil || old != 1 {
+		t.Fatal("SetMaxReason failed", old, err)
+	}
+	want := AuditEntry{Time: c.sem.Audit[0].Time, Action: "set-max", Actor: "a", Reason: "more capacity", Detail: "1 -> 3"}
+	if len(c.sem.Audit) != 1 || c.sem.Audit[0] != want {
+		t.Error("SetMaxReason was not audited", c.sem.Audit)
+	}
+
+	for i := 0; i < auditLimit+5; i++ {
+		al.SetMax(i%2 + 1)
+	}
+	if len(c.sem.Audit) != auditLimit {
+		t.Error("Audit trail was not trimmed", len(c.sem.Audit))
+	}
+}
+
+func TestEvict(t *testing.T) {
+	c := testLockClient{}
+	c.Init()
+	al, err := New("path", "a", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	opl, err := New("path", "operator", &c)
+	if err != nil {
+		t.Error(err)
+	}
+
+	if err := opl.Evict("a", "stuck"); err != ErrNotExist {
+		t.Error("Evicting a non-holder should have failed", err)
+	}
+
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if err := opl.Evict("a", "stuck"); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.sem.Holders) != 0 || c.sem.Semaphore != 1 {
+		t.Error("Evict did not remove a from the holders", c.sem)
+	}
+
+	revoked, ok := c.sem.Revoked["a"]
+	if !ok || revoked.Actor != "operator" || revoked.Reason != "stuck" {
+		t.Error("Evict did not leave a revocation marker for a", c.sem.Revoked)
+	}
+	if last := c.sem.Audit[len(c.sem.Audit)-1]; last != revoked {
+		t.Error("Evict was not audited", c.sem.Audit)
+	}
+
+	if err := al.Unlock(); err != ErrNotExist {
+		t.Error("Unlocking after being evicted should have failed", err)
+	}
+	if err := al.Lock(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.sem.Revoked["a"]; ok {
+		t.Error("Locking again did not clear the revocation marker")
+	}
+}
+
+func TestOpenDoesNotCreate(t *testing.T) {
+	c := &testLockClient{}
+	l := Open("locks/test", "a", c)
+	if c.sem != nil {
+		t.Errorf("Open created the semaphore: %v", c.sem)
+	}
+	if c.path != "locks/test" || l.Path != "locks/test" {
+		t.Errorf("Open set path %q and %q, want locks/test", c.path, l.Path)
+	}
+}
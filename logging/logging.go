@@ -0,0 +1,201 @@
+// Package logging is the leveled, structured logging used throughout
+// consul-semaphore.  Packages log through a Logger, which library users
+// may replace with their own, either per Semaphore or for everything
+// created afterwards through SetDefault.
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log line.
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+	return levelNames[l]
+}
+
+// ParseLevel parses a level's name.
+func ParseLevel(s string) (Level, error) {
+	for i, name := range levelNames {
+		if strings.EqualFold(s, name) {
+			return Level(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown log level %q", s)
+}
+
+// Field is a key and value attached to a log line, such as the path of the
+// semaphore concerned.  The fields used throughout are path, holder,
+// index, attempt and error.
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F returns a Field.
+func F(key string, value interface{}) Field {
+	return Field{key, value}
+}
+
+// Logger receives log lines.  It must be safe for concurrent use.
+type Logger interface {
+	Log(level Level, msg string, fields ...Field)
+}
+
+// Discard drops everything logged to it.
+var Discard Logger = discard{}
+
+type discard struct{}
+
+func (discard) Log(Level, string, ...Field) {}
+
+// Formats New can write in.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// New returns a Logger writing lines of level or above to w, formatted as
+// text or json.
+func New(w io.Writer, level Level, format string) (Logger, error) {
+	if format != FormatText && format != FormatJSON {
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+	return &writer{w: w, level: level, json: format == FormatJSON}, nil
+}
+
+// now is replaced by tests.
+var now = time.Now
+
+type writer struct {
+	mu    sync.Mutex
+	w     io.Writer
+	level Level
+	json  bool
+}
+
+func (l *writer) Log(level Level, msg string, fields ...Field) {
+	if level < l.level {
+		return
+	}
+
+	var b bytes.Buffer
+	t := now().UTC().Format(time.RFC3339Nano)
+	if l.json {
+		writeJSON(&b, t, level, msg, fields)
+	} else {
+		writeText(&b, t, level, msg, fields)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(b.Bytes())
+}
+
+func writeText(b *bytes.Buffer, t string, level Level, msg string, fields []Field) {
+	fmt.Fprintf(b, "%s %-5s %s", t, strings.ToUpper(level.String()), msg)
+	for _, f := range fields {
+		v := fmt.Sprint(value(f.Value))
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+		fmt.Fprintf(b, " %s=%s", f.Key, v)
+	}
+	b.WriteString("\n")
+}
+
+func writeJSON(b *bytes.Buffer, t string, level Level, msg string, fields []Field) {
+	b.WriteString("{")
+	writeJSONField(b, "time", t)
+	b.WriteString(",")
+	writeJSONField(b, "level", level.String())
+	b.WriteString(",")
+	writeJSONField(b, "msg", msg)
+	for _, f := range fields {
+		b.WriteString(",")
+		writeJSONField(b, f.Key, value(f.Value))
+	}
+	b.WriteString("}\n")
+}
+
+func writeJSONField(b *bytes.Buffer, key string, v interface{}) {
+	k, _ := json.Marshal(key)
+	b.Write(k)
+	b.WriteString(":")
+	if j, err := json.Marshal(v); err == nil {
+		b.Write(j)
+	} else {
+		j, _ = json.Marshal(fmt.Sprint(v))
+		b.Write(j)
+	}
+}
+
+// value makes v presentable: errors and durations as their strings.
+func value(v interface{}) interface{} {
+	switch v := v.(type) {
+	case error:
+		return v.Error()
+	case time.Duration:
+		return v.String()
+	}
+	return v
+}
+
+// With returns a Logger adding fields to every line logged to l.
+func With(l Logger, fields ...Field) Logger {
+	if w, ok := l.(*with); ok {
+		return &with{w.l, append(append([]Field(nil), w.fields...), fields...)}
+	}
+	return &with{l, fields}
+}
+
+type with struct {
+	l      Logger
+	fields []Field
+}
+
+func (w *with) Log(level Level, msg string, fields ...Field) {
+	w.l.Log(level, msg, append(append([]Field(nil), w.fields...), fields...)...)
+}
+
+var (
+	defaultMu     sync.Mutex
+	defaultLogger Logger = &writer{w: os.Stderr, level: Info}
+)
+
+// Default returns the Logger used by everything created without one of
+// its own.  Unless replaced, it writes info and above to stderr as text.
+func Default() Logger {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	return defaultLogger
+}
+
+// SetDefault replaces the Default logger for everything created
+// afterwards.
+func SetDefault(l Logger) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultLogger = l
+}
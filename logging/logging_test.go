@@ -0,0 +1,54 @@
+package logging
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLog(t *testing.T) {
+	now = func() time.Time { return time.Date(2014, 11, 5, 10, 0, 0, 0, time.UTC) }
+	defer func() { now = time.Now }()
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{FormatText, `2014-11-05T10:00:00Z WARN  retrying path=global/semaphore holder="web 1" attempt=2 error="CAS failed"
+`},
+		{FormatJSON, `{"time":"2014-11-05T10:00:00Z","level":"warn","msg":"retrying","path":"global/semaphore","holder":"web 1","attempt":2,"error":"CAS failed"}
+`},
+	}
+
+	for _, test := range tests {
+		var b bytes.Buffer
+		l, err := New(&b, Info, test.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		l = With(l, F("path", "global/semaphore"), F("holder", "web 1"))
+
+		l.Log(Debug, "dropped")
+		l.Log(Warn, "retrying", F("attempt", 2), F("error", errors.New("CAS failed")))
+
+		if got := b.String(); got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.format, got, test.want)
+		}
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range []string{"debug", "INFO", "Warn", "error"} {
+		l, err := ParseLevel(name)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+		}
+		if l2, _ := ParseLevel(l.String()); l2 != l {
+			t.Errorf("%s: did not round trip", name)
+		}
+	}
+	if _, err := ParseLevel("loud"); err == nil {
+		t.Error("parsed an unknown level")
+	}
+}
@@ -2,8 +2,6 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
-	"log"
 	"os"
 
 	"github.com/mitchellh/cli"
@@ -14,28 +12,19 @@ func main() {
 }
 
 func realMain() int {
-	verbose := false
-
 	// Get the command line args. We shortcut "--version" and "-v" to
-	// just show the version.
+	// just show the version.  Logging is set up by each command's flags.
 	args := os.Args[1:]
 	for _, arg := range args {
-		switch {
-		case arg == "-v" || arg == "--version":
+		if arg == "-v" || arg == "--version" {
 			newArgs := make([]string, len(args)+1)
 			newArgs[0] = "version"
 			copy(newArgs[1:], args)
 			args = newArgs
 			break
-		case arg == "-verbose":
-			verbose = true
 		}
 	}
 
-	if !verbose {
-		log.SetOutput(ioutil.Discard)
-	}
-
 	cli := &cli.CLI{
 		Args:     args,
 		Commands: Commands,
@@ -0,0 +1,252 @@
+// Package metrics keeps counters, gauges and histograms of semaphore
+// activity and serves them in the Prometheus text exposition format.
+// Metrics are created once, at package level, and registered with the
+// Default registry.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the histogram buckets used unless others are given,
+// in seconds.
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10, 30, 60, 300, 900}
+
+// Registry holds metrics in the order they were registered.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+}
+
+// Default is the registry the New functions register with.
+var Default = &Registry{}
+
+// kind is a metric's Prometheus type.
+type kind string
+
+const (
+	counter   kind = "counter"
+	gauge     kind = "gauge"
+	histogram kind = "histogram"
+)
+
+// metric is a named family of series, one per combination of label values.
+type metric struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+type series struct {
+	labels []string
+
+	// value is a counter or gauge's value, or a histogram's sum.
+	value   float64
+	count   uint64
+	buckets []uint64
+}
+
+func (r *Registry) register(m *metric) *metric {
+	m.series = make(map[string]*series)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.metrics = append(r.metrics, m)
+	return m
+}
+
+// with returns the series for labels, creating it the first time.
+func (m *metric) with(labels []string, f func(*series)) {
+	if len(labels) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d labels, got %d", m.name, len(m.labels), len(labels)))
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := strings.Join(labels, "\xff")
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{labels: append([]string(nil), labels...)}
+		if m.kind == histogram {
+			s.buckets = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	f(s)
+}
+
+// Counter is a value that only goes up.
+type Counter struct{ m *metric }
+
+// NewCounter registers a counter with Default.
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{Default.register(&metric{name: name, help: help, kind: counter, labels: labels})}
+}
+
+// Inc adds one to the series for labels.
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v, which must not be negative, to the series for labels.
+func (c *Counter) Add(v float64, labels ...string) {
+	c.m.with(labels, func(s *series) { s.value += v })
+}
+
+// Gauge is a value that goes up and down.
+type Gauge struct{ m *metric }
+
+// NewGauge registers a gauge with Default.
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{Default.register(&metric{name: name, help: help, kind: gauge, labels: labels})}
+}
+
+// Set sets the series for labels to v.
+func (g *Gauge) Set(v float64, labels ...string) {
+	g.m.with(labels, func(s *series) { s.value = v })
+}
+
+// Histogram counts observations into buckets.
+type Histogram struct{ m *metric }
+
+// NewHistogram registers a histogram with Default, using DefaultBuckets if
+// buckets is nil.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	buckets = append([]float64(nil), buckets...)
+	sort.Float64s(buckets)
+
+	return &Histogram{Default.register(&metric{
+		name: name, help: help, kind: histogram, labels: labels, buckets: buckets,
+	})}
+}
+
+// Observe records v in the series for labels.
+func (h *Histogram) Observe(v float64, labels ...string) {
+	h.m.with(labels, func(s *series) {
+		s.value += v
+		s.count++
+		for i, le := range h.m.buckets {
+			if v <= le {
+				s.buckets[i]++
+			}
+		}
+	})
+}
+
+// WriteTo writes every metric in the text exposition format.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := append([]*metric(nil), r.metrics...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, m := range metrics {
+		m.write(cw)
+	}
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *metric) write(w *countingWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprintf(w, "# HELP %s %s\n", m.name, escapeHelp(m.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for k := range m.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		s := m.series[k]
+		if m.kind != histogram {
+			fmt.Fprintf(w, "%s%s %s\n", m.name, m.labelSet(s.labels, ""), format(s.value))
+			continue
+		}
+
+		for i, le := range m.buckets {
+			fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.labels, format(le)), s.buckets[i])
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", m.name, m.labelSet(s.labels, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", m.name, m.labelSet(s.labels, ""), format(s.value))
+		fmt.Fprintf(w, "%s_count%s %d\n", m.name, m.labelSet(s.labels, ""), s.count)
+	}
+}
+
+// labelSet formats values as the metric's labels, adding le if set.
+func (m *metric) labelSet(values []string, le string) string {
+	var pairs []string
+	for i, name := range m.labels {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", name, escapeLabel(values[i])))
+	}
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func format(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+// countingWriter keeps the first error and the bytes written, so writing
+// can go on unchecked.
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+// Handler serves the Default registry's metrics.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		Default.WriteTo(w)
+	})
+}
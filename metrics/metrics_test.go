@@ -0,0 +1,53 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestWriteTo(t *testing.T) {
+	c := NewCounter("test_conflicts_total", "Conflicts.", "path")
+	g := NewGauge("test_holders", "Holders\nright now.", "path")
+	h := NewHistogram("test_wait_seconds", "Waits.", []float64{1, 0.5}, "path")
+
+	c.Inc(`a"b`)
+	c.Add(2, `a"b`)
+	g.Set(3, "x")
+	g.Set(1, "x")
+	h.Observe(0.25, "x")
+	h.Observe(0.75, "x")
+	h.Observe(5, "x")
+
+	var b bytes.Buffer
+	if _, err := Default.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_conflicts_total Conflicts.
+# TYPE test_conflicts_total counter
+test_conflicts_total{path="a\"b"} 3
+# HELP test_holders Holders\nright now.
+# TYPE test_holders gauge
+test_holders{path="x"} 1
+# HELP test_wait_seconds Waits.
+# TYPE test_wait_seconds histogram
+test_wait_seconds_bucket{path="x",le="0.5"} 1
+test_wait_seconds_bucket{path="x",le="1"} 2
+test_wait_seconds_bucket{path="x",le="+Inf"} 3
+test_wait_seconds_sum{path="x"} 6
+test_wait_seconds_count{path="x"} 3
+`
+	if got := b.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestWrongLabels(t *testing.T) {
+	defer func() {
+		if r := recover(); r == nil || !strings.Contains(r.(string), "takes 1 labels") {
+			t.Errorf("got panic %v", r)
+		}
+	}()
+	NewCounter("test_wrong_total", "Wrong.", "path").Inc()
+}
@@ -0,0 +1,62 @@
+package semaphore
+
+import (
+	"context"
+	"net"
+	"net/url"
+
+	lock "github.com/ryanschneider/consul-semaphore/lock"
+)
+
+// Class is a broad kind of failure, so that exit codes, metrics and errors
+// served over HTTP all sort failures the same way.
+type Class string
+
+const (
+	// ClassNone is the class of a nil error.
+	ClassNone Class = ""
+
+	// ClassExhausted means the semaphore could not be acquired without
+	// waiting: it was exhausted, rate limited, cooling down, frozen, or
+	// held back by a gate.
+	ClassExhausted Class = "exhausted"
+
+	// ClassTimeout means waiting for the semaphore timed out.
+	ClassTimeout Class = "timeout"
+
+	// ClassAbandoned means waiting for the semaphore was given up.
+	ClassAbandoned Class = "abandoned"
+
+	// ClassUnreachable means Consul could not be contacted.
+	ClassUnreachable Class = "unreachable"
+
+	// ClassNotHeld means the holder did not hold the semaphore.
+	ClassNotHeld Class = "not-held"
+
+	// ClassOther is any other failure.
+	ClassOther Class = "error"
+)
+
+// Classify returns the class of err.
+func Classify(err error) Class {
+	switch err.(type) {
+	case nil:
+		return ClassNone
+	case lock.SemaphoreExhaustedErr, lock.RateLimitedErr, lock.CooldownErr, GateClosedErr:
+		return ClassExhausted
+	case *url.Error, net.Error:
+		return ClassUnreachable
+	}
+
+	switch err {
+	case lock.ErrFrozen:
+		return ClassExhausted
+	case ErrAcquireTimeout:
+		return ClassTimeout
+	case context.Canceled:
+		return ClassAbandoned
+	case lock.ErrNotExist:
+		return ClassNotHeld
+	}
+	return ClassOther
+}
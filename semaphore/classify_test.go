@@ -0,0 +1,33 @@
+package semaphore
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"testing"
+
+	lock "github.com/ryanschneider/consul-semaphore/lock"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		err   error
+		class Class
+	}{
+		{nil, ClassNone},
+		{lock.SemaphoreExhaustedErr(2), ClassExhausted},
+		{GateClosedErr{Reason: "web failing"}, ClassExhausted},
+		{lock.ErrFrozen, ClassExhausted},
+		{ErrAcquireTimeout, ClassTimeout},
+		{context.Canceled, ClassAbandoned},
+		{&url.Error{Op: "Get", URL: "http://consul", Err: errors.New("refused")}, ClassUnreachable},
+		{lock.ErrNotExist, ClassNotHeld},
+		{errors.New("boom"), ClassOther},
+	}
+
+	for _, test := range tests {
+		if class := Classify(test.err); class != test.class {
+			t.Errorf("Classify(%v) = %q, want %q", test.err, class, test.class)
+		}
+	}
+}
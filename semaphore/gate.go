@@ -0,0 +1,43 @@
+package semaphore
+
+import (
+	"context"
+	"fmt"
+)
+
+// Gate holds back Acquire on a condition outside of the Semaphore itself,
+// such as the health of the cluster being restarted.  Gates are checked
+// before every attempt to lock.
+type Gate interface {
+	// Check returns nil if acquiring may go ahead, a GateClosedErr if it
+	// may not, or any other error if the condition could not be checked.
+	Check() error
+
+	// Wait blocks until the result of Check may have changed, or ctx is
+	// done.
+	Wait(ctx context.Context) error
+}
+
+// GateClosedErr is returned by a Gate that is holding back Acquire.
+type GateClosedErr struct {
+	Reason string
+}
+
+func (e GateClosedErr) Error() string {
+	return fmt.Sprintf("gate closed: %s", e.Reason)
+}
+
+// AddGate makes Acquire wait for, or refuse on, g as well as the Semaphore.
+func (s *Semaphore) AddGate(g Gate) {
+	s.gates = append(s.gates, g)
+}
+
+// checkGates returns the first gate that is not open, along with its error.
+func (s *Semaphore) checkGates() (Gate, error) {
+	for _, g := range s.gates {
+		if err := g.Check(); err != nil {
+			return g, err
+		}
+	}
+	return nil, nil
+}
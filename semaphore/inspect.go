@@ -0,0 +1,37 @@
+package semaphore
+
+import (
+	api "github.com/armon/consul-api"
+	lock "github.com/ryanschneider/consul-semaphore/lock"
+)
+
+// Inspect returns the state of the semaphore at path without creating it,
+// as New would.  Unless consistent is set the read may be answered by any
+// Consul server, and so be slightly stale.
+func Inspect(apiClient *api.Client, path string, consistent bool) (*lock.Semaphore, error) {
+	client, err := lock.NewConsulLockClient(apiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = client.SetPath(path); err != nil {
+		return nil, err
+	}
+
+	if consistent {
+		return client.Get()
+	}
+	return client.GetStale()
+}
+
+// List returns every semaphore under prefix, keyed by path, along with the
+// paths of keys under prefix that are not semaphores.  All of them are read
+// in a single, possibly stale, request.
+func List(apiClient *api.Client, prefix string) (sems map[string]*lock.Semaphore, invalid []string, err error) {
+	client, err := lock.NewConsulLockClient(apiClient)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return client.List(prefix)
+}
@@ -0,0 +1,23 @@
+package semaphore
+
+import (
+	"github.com/ryanschneider/consul-semaphore/metrics"
+)
+
+var (
+	acquireDuration = metrics.NewHistogram("consul_semaphore_acquire_duration_seconds",
+		"Time taken by Acquire, by result.", nil, "path", "result")
+	waitDuration = metrics.NewHistogram("consul_semaphore_wait_duration_seconds",
+		"Time Acquire spent queued as a waiter.", nil, "path")
+	releaseFailures = metrics.NewCounter("consul_semaphore_release_failures_total",
+		"Releases that failed.", "path")
+)
+
+// acquireResult names the outcome of Acquire for its metrics: "acquired",
+// or the class of its error.
+func acquireResult(err error) string {
+	if err == nil {
+		return "acquired"
+	}
+	return string(Classify(err))
+}
@@ -1,14 +1,21 @@
 package semaphore
 
 import (
-	"log"
-	"math/rand"
+	"context"
+	"errors"
+	"fmt"
 	"time"
 
 	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/backoff"
 	lock "github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/logging"
 )
 
+// ErrAcquireTimeout is returned when waiting to acquire a Semaphore ran past
+// its deadline.
+var ErrAcquireTimeout = errors.New("timed out waiting for semaphore")
+
 // Semaphore represents a Consul-backed semaphore.
 // Based off of the etcd semaphore used in CoreOS' Locksmith,
 // Semaphore can be used to coordiate a set of workers around
@@ -18,15 +25,30 @@ type Semaphore struct {
 	Path   string
 	Holder string
 	lock   *lock.Lock
+	gates  []Gate
+	slot   int
+	log    logging.Logger
+	policy backoff.Policy
+	consul *api.Client
+
+	// sessionGone reports whether a Consul session has expired.  It looks
+	// the session up through consul unless set, as tests do.
+	sessionGone func(id string) (bool, error)
 }
 
-// New creates and returns a new Semaphore.
+// New creates and returns a new Semaphore, using the default Consul agent.
 func New(path string, holder string) (s *Semaphore, err error) {
 	apiClient, err := api.NewClient(api.DefaultConfig())
 	if err != nil {
 		return nil, err
 	}
 
+	return NewWithClient(path, holder, apiClient)
+}
+
+// NewWithClient creates and returns a new Semaphore stored using the given
+// Consul client.
+func NewWithClient(path string, holder string, apiClient *api.Client) (s *Semaphore, err error) {
 	client, err := lock.NewConsulLockClient(apiClient)
 	if err != nil {
 		return nil, err
@@ -37,7 +59,61 @@ func New(path string, holder string) (s *Semaphore, err error) {
 		return nil, err
 	}
 
-	return &Semaphore{path, holder, lock}, nil
+	s = &Semaphore{Path: path, Holder: holder, lock: lock, slot: -1, consul: apiClient}
+	s.SetLogger(logging.Default())
+	return s, nil
+}
+
+// Open returns the Semaphore at path without creating it, as NewWithClient
+// would, for watching one that should already exist.  If it doesn't, Get,
+// Subscribe and WaitFor fail with lock.SemaphoreNotFoundErr.
+func Open(path string, holder string, apiClient *api.Client) (s *Semaphore, err error) {
+	client, err := lock.NewConsulLockClient(apiClient)
+	if err != nil {
+		return nil, err
+	}
+
+	s = &Semaphore{Path: path, Holder: holder, lock: lock.Open(path, holder, client), slot: -1, consul: apiClient}
+	s.SetLogger(logging.Default())
+	return s, nil
+}
+
+// SetLogger makes the Semaphore log to l, with its path and holder as
+// fields.  Semaphores log to logging.Default() otherwise.
+func (s *Semaphore) SetLogger(l logging.Logger) {
+	s.log = logging.With(l, logging.F("path", s.Path), logging.F("holder", s.Holder))
+	s.lock.SetLogger(l)
+}
+
+// SetBackoff sets the policy for retrying after check-and-set conflicts
+// and failures to reach Consul.  Semaphores use backoff.Default otherwise.
+func (s *Semaphore) SetBackoff(p backoff.Policy) {
+	s.policy = p
+}
+
+// SetSession ties later acquisitions to the Consul session id, which the
+// caller keeps renewing.  Once the session expires, the next acquire that
+// finds the Semaphore exhausted evicts the hold.
+func (s *Semaphore) SetSession(id string) {
+	s.lock.SetSession(id)
+}
+
+func (s *Semaphore) backoff() backoff.Backoff {
+	if s.policy == nil {
+		return backoff.Default.New()
+	}
+	return s.policy.New()
+}
+
+// transient reports whether err is a failure to reach Consul, which may
+// well succeed if retried.
+func transient(err error) bool {
+	return Classify(err) == ClassUnreachable
+}
+
+// Get returns the current state of the Semaphore.
+func (s *Semaphore) Get() (*lock.Semaphore, error) {
+	return s.lock.Get()
 }
 
 // SetMax sets the maximum number of concurrent holders of a Semaphore.
@@ -45,73 +121,437 @@ func New(path string, holder string) (s *Semaphore, err error) {
 // lowered below the current number of holders, no one will be signalled until
 // the number of holders drops below max.
 func (s *Semaphore) SetMax(max uint) (oldMax uint, err error) {
-	_, iOldMax, err := s.lock.SetMax(int(max))
+	change, err := s.ChangeMax(max, "")
+	return change.Old, err
+}
+
+// MaxChange describes a change to a Semaphore's max.
+type MaxChange struct {
+	Old, New uint
+
+	// Holders is how many holders the Semaphore had at the time, which
+	// may be more than New after lowering the max.
+	Holders int
+
+	// Over has the slots that are now New or more, keyed by holder,
+	// when the Semaphore's slots are numbered.
+	Over map[string]int
+}
+
+// ChangeMax is SetMax, recording the change and the reason for it in the
+// Semaphore's audit trail, and describing the result.
+func (s *Semaphore) ChangeMax(max uint, reason string) (change MaxChange, err error) {
+	sem, oldMax, err := s.lock.SetMaxReason(int(max), reason)
 	if err != nil {
-		return 0, err
+		return MaxChange{}, err
 	}
 
-	oldMax = uint(iOldMax)
-	return oldMax, nil
+	return MaxChange{
+		Old:     uint(oldMax),
+		New:     max,
+		Holders: len(sem.Holders),
+		Over:    sem.SlotsOver(int(max)),
+	}, nil
+}
+
+// WaitFor blocks until condition holds for the Semaphore's state, watching
+// it rather than polling, and returns that state.  It gives up with
+// ctx.Err() once ctx is done.
+func (s *Semaphore) WaitFor(ctx context.Context, condition func(*lock.Semaphore) bool) (*lock.Semaphore, error) {
+	cur, err := s.lock.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	for !condition(cur) {
+		if cur, err = s.watchFrom(ctx, cur); err != nil {
+			return nil, err
+		}
+	}
+
+	return cur, nil
+}
+
+// WaitDrain blocks until the Semaphore has no more holders than its max,
+// such as after lowering it.
+func (s *Semaphore) WaitDrain(ctx context.Context) error {
+	_, err := s.WaitFor(ctx, func(sem *lock.Semaphore) bool {
+		return len(sem.Holders) <= sem.Max
+	})
+	return err
+}
+
+// SetNumbered turns slot numbering on or off.  While on, each holder is
+// given the lowest free slot in [0, max), which it keeps until it releases.
+func (s *Semaphore) SetNumbered(numbered bool) (err error) {
+	return s.lock.SetNumbered(numbered)
+}
+
+// Slot returns the slot given to the holder by the last successful
+// Acquire, or -1 if the Semaphore's slots are not numbered.
+func (s *Semaphore) Slot() int {
+	return s.slot
+}
+
+// SetRate limits the Semaphore to at most limit acquisitions per window, on
+// top of the concurrency limit set with SetMax.  A limit of zero removes the
+// rate limit.
+func (s *Semaphore) SetRate(limit uint, window time.Duration) (err error) {
+	return s.lock.SetRate(int(limit), window)
+}
+
+// SetCooldown sets the minimum time between a Release and the next grant.
+// Acquirers waiting out a cooldown sleep until it ends.
+func (s *Semaphore) SetCooldown(cooldown time.Duration) (err error) {
+	return s.lock.SetCooldown(cooldown)
+}
+
+// Freeze stops the Semaphore from granting any further acquisitions until
+// Unfreeze is called, for example to halt a rollout that went wrong.
+// Current holders may still Release.
+func (s *Semaphore) Freeze(reason string) (err error) {
+	return s.lock.Freeze(reason)
+}
+
+// Unfreeze lets a frozen Semaphore grant acquisitions again.
+func (s *Semaphore) Unfreeze() (err error) {
+	return s.lock.Unfreeze()
+}
+
+// retryAfter is implemented by lock errors that know when the lock
+// could next succeed, such as lock.RateLimitedErr and lock.CooldownErr.
+type retryAfter interface {
+	RetryAfter() time.Duration
 }
 
 // Acquire acquires a portion of the Semaphore, optionally waiting if the
-// Semaphore is currently maxed out.
+// Semaphore is currently maxed out or one of its gates is closed.  While
+// waiting, the holder is listed as a waiter on the Semaphore.
 func (s *Semaphore) Acquire(wait bool) (err error) {
-	for {
-		log.Printf("Holder %v: acquiring..", s.Holder)
-		err = s.lock.Lock()
+	return s.AcquireContext(context.Background(), wait)
+}
+
+// AcquireTimeout is Acquire, waiting at most timeout for the Semaphore.
+func (s *Semaphore) AcquireTimeout(timeout time.Duration) (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	return s.AcquireContext(ctx, true)
+}
+
+// AcquireContext is Acquire, but gives up waiting once ctx is done, in which
+// case the holder is removed from the waiters.  ErrAcquireTimeout is returned
+// if ctx's deadline passed, and ctx.Err() otherwise.
+func (s *Semaphore) AcquireContext(ctx context.Context, wait bool) (err error) {
+	start := time.Now()
+	enqueued := false
+	var waitStart time.Time
+	defer func() {
+		acquireDuration.Observe(time.Since(start).Seconds(), s.Path, acquireResult(err))
+		if enqueued {
+			waitDuration.Observe(time.Since(waitStart).Seconds(), s.Path)
+		}
+	}()
+	defer func() {
+		if err == context.DeadlineExceeded {
+			err = ErrAcquireTimeout
+		}
+	}()
+	defer func() {
+		// a successful Lock already removed us from the waiters.  Left
+		// behind, we would stay listed as waiting for good.
+		if err != nil && enqueued {
+			derr := s.retry("dequeue", s.lock.Dequeue)
+			if derr != nil && derr != lock.ErrNotExist {
+				s.log.Log(logging.Error, "dequeue failed", logging.F("error", derr))
+			}
+		}
+	}()
+
+	enqueue := func() {
+		if !enqueued {
+			if qerr := s.lock.Enqueue(); qerr == nil || qerr == lock.ErrExist {
+				enqueued = true
+				waitStart = time.Now()
+			}
+		}
+	}
+
+	// retries backs off from conflicts and failures to reach Consul, and
+	// starts over whenever the semaphore is seen to change.
+	var retries backoff.Backoff
+	backOff := func(cause error, a logging.Field) error {
+		if retries == nil {
+			retries = s.backoff()
+		}
+		d, ok := retries.Next()
+		if !ok {
+			return cause
+		}
+		s.log.Log(logging.Debug, "backing off", a, logging.F("error", cause), logging.F("delay", d))
+		return sleep(ctx, d)
+	}
+
+	var gate Gate
+	for attempt := 1; ; attempt++ {
+		a := logging.F("attempt", attempt)
+		gate, err = s.checkGates()
+		if err != nil {
+			if transient(err) {
+				if err = backOff(err, a); err != nil {
+					return err
+				}
+				continue
+			}
+			if _, closed := err.(GateClosedErr); !closed || !wait {
+				return err
+			}
+
+			enqueue()
+			s.log.Log(logging.Info, "gate closed, waiting", a, logging.F("error", err))
+			if err = abandonable(ctx, func() error { return gate.Wait(ctx) }); err != nil {
+				return err
+			}
+			continue
+		}
+
+		s.log.Log(logging.Debug, "acquiring", a)
+		s.slot, err = s.lock.LockSlot()
 		if err == nil {
+			s.log.Log(logging.Info, "acquired", a, logging.F("slot", s.slot))
 			return nil
 		}
 
+		// conflicts and unreachable Consul are retried, waiting or not
+		if err == lock.CheckAndSetFailedErr || transient(err) {
+			s.log.Log(logging.Debug, "acquire failed, retrying", a, logging.F("error", err))
+			if err = backOff(err, a); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// holders whose session has expired will never release, so make
+		// room by evicting them
+		if _, ok := err.(lock.SemaphoreExhaustedErr); ok && s.evictExpired(a) {
+			continue
+		}
+
 		// only go again if we are waiting
 		if !wait {
 			return err
 		}
 
 		_, isExhausted := err.(lock.SemaphoreExhaustedErr)
-		casFailed := (err == lock.CheckAndSetFailedErr)
+		isFrozen := (err == lock.ErrFrozen)
+		delayed, isDelayed := err.(retryAfter)
+
+		if isExhausted || isFrozen || isDelayed {
+			enqueue()
+		}
 
 		switch {
 		case isExhausted:
-			log.Printf("Holder %v: Semaphore exhausted, trying again", s.Holder)
-		case casFailed:
-			log.Printf("Holder %v: CheckAndSet failed, trying again", s.Holder)
+			s.log.Log(logging.Info, "semaphore exhausted, waiting", a)
+		case isFrozen:
+			s.log.Log(logging.Info, "semaphore frozen, waiting for it to unfreeze", a)
+		case isDelayed:
+			// the semaphore won't change its mind until then, so sleep
+			// rather than watching
+			d := delayed.RetryAfter()
+			s.log.Log(logging.Info, "delayed, sleeping", a, logging.F("error", err), logging.F("retry_after", d))
+			if err = sleep(ctx, d); err != nil {
+				return err
+			}
+			continue
 		default:
 			return err
 		}
 
-		changed, err := s.lock.Watch()
+		changed, err := s.watch(ctx)
+		if transient(err) {
+			if err = backOff(err, a); err != nil {
+				return err
+			}
+			continue
+		}
 		if err != nil {
 			return err
 		}
 
-		log.Printf("Holder %v: Watch woke up, changed: %v", s.Holder, changed)
+		s.log.Log(logging.Debug, "watch woke up", a, logging.F("changed", changed))
 		if changed {
-			// Sleep here to avoid too many CAS errors on thundering herd
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			time.Sleep(time.Duration(r.Intn(1000)) * time.Millisecond)
+			// Sleep here to avoid too many CAS errors on thundering herd,
+			// as long as the policy's first delay
+			retries = nil
+			d, _ := s.backoff().Next()
+			if err = sleep(ctx, d); err != nil {
+				return err
+			}
 			continue
 		}
 	}
+}
 
-	return err
+// watch is lock.Watch, but returns early with ctx.Err() once ctx is done.
+func (s *Semaphore) watch(ctx context.Context) (bool, error) {
+	type result struct {
+		changed bool
+		err     error
+	}
+	done := make(chan result, 1)
+	go func() {
+		changed, err := s.lock.Watch()
+		done <- result{changed, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.changed, r.err
+	case <-ctx.Done():
+		return false, ctx.Err()
+	}
+}
+
+// watchFrom is WatchFrom, abandoned once ctx is done, as in watch.
+func (s *Semaphore) watchFrom(ctx context.Context, prev *lock.Semaphore) (*lock.Semaphore, error) {
+	type result struct {
+		sem *lock.Semaphore
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		sem, err := s.lock.WatchFrom(prev)
+		done <- result{sem, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.sem, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// abandonable runs f, returning early with ctx.Err() once ctx is done.
+// Long-polls can't be interrupted, so f is left to finish in the background.
+func abandonable(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// sleep pauses for d, returning ctx.Err() early if ctx is done.
+func sleep(ctx context.Context, d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Evict removes another holder from the Semaphore, recording that this
+// Semaphore's Holder did so and why.  The evicted holder can find out
+// through Evicted.  Conflicts are retried, as in Release.
+func (s *Semaphore) Evict(holder string, reason string) (err error) {
+	return s.retry("evict", func() error {
+		return s.lock.Evict(holder, reason)
+	})
+}
+
+// evictExpired evicts the holders whose Consul session has expired,
+// reporting whether it evicted any.  Failures are logged, as they only
+// leave the Semaphore as exhausted as it was.
+func (s *Semaphore) evictExpired(a logging.Field) (evicted bool) {
+	gone := s.sessionGone
+	if gone == nil {
+		if s.consul == nil {
+			return false
+		}
+		gone = func(id string) (bool, error) {
+			entry, _, err := s.consul.Session().Info(id, nil)
+			return entry == nil, err
+		}
+	}
+
+	sem, err := s.lock.Get()
+	if err != nil {
+		return false
+	}
+
+	for _, holder := range sem.Holders {
+		session := sem.HolderInfo[holder].Session
+		if session == "" {
+			continue
+		}
+		expired, err := gone(session)
+		if err != nil {
+			s.log.Log(logging.Warn, "session lookup failed", a, logging.F("session", session), logging.F("error", err))
+			continue
+		}
+		if !expired {
+			continue
+		}
+
+		s.log.Log(logging.Info, "evicting holder with expired session", a,
+			logging.F("evicted", holder), logging.F("session", session))
+		err = s.Evict(holder, fmt.Sprintf("session %s expired", session))
+		switch err {
+		case nil:
+			evicted = true
+		case lock.ErrNotExist:
+			// released or evicted meanwhile
+			evicted = true
+		default:
+			s.log.Log(logging.Error, "evict failed", a, logging.F("evicted", holder), logging.F("error", err))
+		}
+	}
+	return evicted
+}
+
+// retry runs op until it succeeds, fails in a way not worth retrying, or
+// the backoff policy gives up, returning op's last error.
+func (s *Semaphore) retry(what string, op func() error) (err error) {
+	retries := s.backoff()
+	for attempt := 1; ; attempt++ {
+		err = op()
+		if err != lock.CheckAndSetFailedErr && !transient(err) {
+			return err
+		}
+
+		d, ok := retries.Next()
+		if !ok {
+			return err
+		}
+		s.log.Log(logging.Debug, what+" failed, retrying", logging.F("attempt", attempt),
+			logging.F("error", err), logging.F("delay", d))
+		time.Sleep(d)
+	}
 }
 
 // Releases releases a portion of the Semaphore.
 // Releasing allows waiting Acquirers to be signalled.
 // Note: In a highly contentious Semaphore, there may be CheckAndSet (CAS)
-// errors writing to the semaphore.  These are handled inside Release, which
-// may lead to Release blocking while it attempts to cleanly write to the KV.
+// errors writing to the semaphore.  These, and failures to reach Consul,
+// are retried inside Release according to the backoff policy, which may
+// lead to Release blocking while it attempts to cleanly write to the KV.
 func (s *Semaphore) Release() (err error) {
-	for {
-		err = s.lock.Unlock()
-		if err == lock.CheckAndSetFailedErr {
-			// Sleep here to avoid too many CAS errors on thundering herd
-			r := rand.New(rand.NewSource(time.Now().UnixNano()))
-			time.Sleep(time.Duration(r.Intn(1000)) * time.Millisecond)
-			continue
-		}
-		return
+	err = s.retry("release", s.lock.Unlock)
+	if err != nil {
+		releaseFailures.Inc(s.Path)
+		s.log.Log(logging.Error, "release failed", logging.F("error", err))
+		return err
 	}
+
+	s.log.Log(logging.Info, "released")
+	return nil
 }
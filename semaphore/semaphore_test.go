@@ -1,11 +1,16 @@
 package semaphore
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"sync"
 	"testing"
+	"time"
+
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/logging"
 )
 
 // TODO: Mock out consul-api so these aren't integration tests
@@ -170,3 +175,116 @@ func BenchmarkContention(b *testing.B) {
 		wg.Wait()
 	}
 }
+
+// slowClient is a LockClient whose watches outlast the test's patience.
+type slowClient struct {
+	mu    sync.Mutex
+	sem   lock.Semaphore
+	delay time.Duration
+}
+
+func (c *slowClient) Init() error          { return nil }
+func (c *slowClient) SetPath(string) error { return nil }
+
+func (c *slowClient) Set(sem *lock.Semaphore) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sem = *sem
+	return nil
+}
+
+func (c *slowClient) Get() (*lock.Semaphore, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	sem := c.sem
+	return &sem, nil
+}
+
+func (c *slowClient) Watch(sem *lock.Semaphore) (bool, error) {
+	time.Sleep(c.delay)
+	return true, nil
+}
+
+func TestWatchAbandoned(t *testing.T) {
+	client := &slowClient{sem: lock.Semaphore{Max: 1}, delay: 50 * time.Millisecond}
+	l, err := lock.New("test/watch", "holder", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Semaphore{Path: "test/watch", Holder: "holder", lock: l, slot: -1}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := s.WaitFor(ctx, func(*lock.Semaphore) bool { return false }); err != context.Canceled {
+		t.Errorf("WaitFor returned %v, want %v", err, context.Canceled)
+	}
+	if changed, err := s.watch(ctx); changed || err != context.Canceled {
+		t.Errorf("watch returned %v, %v, want false, %v", changed, err, context.Canceled)
+	}
+
+	// the abandoned watches finish in the background, which -race checks
+	// don't touch what watch returned
+	time.Sleep(2 * client.delay)
+}
+
+func TestAcquireEvictsExpired(t *testing.T) {
+	tests := []struct {
+		session string
+		want    error
+	}{
+		{"", lock.SemaphoreExhaustedErr(0)},
+		{"live", lock.SemaphoreExhaustedErr(0)},
+		{"expired", nil},
+	}
+
+	for _, test := range tests {
+		client := &slowClient{sem: lock.Semaphore{
+			Max:        1,
+			Holders:    []string{"old"},
+			HolderInfo: map[string]lock.Holder{"old": {Session: test.session}},
+		}}
+		l, err := lock.New("test/sessions", "new", client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &Semaphore{Path: "test/sessions", Holder: "new", lock: l, slot: -1}
+		s.SetLogger(logging.Discard)
+		s.sessionGone = func(id string) (bool, error) {
+			return id == "expired", nil
+		}
+
+		err = s.Acquire(false)
+		if err != test.want {
+			t.Errorf("session %q: Acquire returned %v, want %v", test.session, err, test.want)
+			continue
+		}
+		sem, _ := client.Get()
+		if err == nil && (len(sem.Holders) != 1 || sem.Holders[0] != "new") {
+			t.Errorf("session %q: holders %v, want [new]", test.session, sem.Holders)
+		}
+	}
+}
+
+func TestAcquireTimeoutDequeues(t *testing.T) {
+	client := &slowClient{
+		sem:   lock.Semaphore{Max: 1, Holders: []string{"a"}},
+		delay: 50 * time.Millisecond,
+	}
+	l, err := lock.New("test/timeout", "b", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Semaphore{Path: "test/timeout", Holder: "b", lock: l, slot: -1}
+	s.SetLogger(logging.Discard)
+
+	if err := s.AcquireTimeout(10 * time.Millisecond); err != ErrAcquireTimeout {
+		t.Errorf("AcquireTimeout returned %v, want %v", err, ErrAcquireTimeout)
+	}
+	if sem, _ := client.Get(); len(sem.Waiters) != 0 {
+		t.Errorf("waiters %v, want none after timing out", sem.Waiters)
+	}
+
+	// let the abandoned watch finish, as in TestWatchAbandoned
+	time.Sleep(2 * client.delay)
+}
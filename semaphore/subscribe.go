@@ -0,0 +1,187 @@
+package semaphore
+
+import (
+	"context"
+	"time"
+
+	lock "github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/logging"
+)
+
+// EventType identifies the kind of change an Event describes.
+type EventType string
+
+const (
+	HolderAdded    EventType = "holder-added"
+	HolderRemoved  EventType = "holder-removed"
+	MaxChanged     EventType = "max-changed"
+	WaiterEnqueued EventType = "waiter-enqueued"
+	Deleted        EventType = "deleted"
+)
+
+// Event describes a single change to a Semaphore's state.  Before and After
+// are snapshots of the whole semaphore around the change, or around the
+// poll that found it; After is nil for Deleted events.  Holder is set for
+// holder and waiter events.
+type Event struct {
+	Type   EventType
+	Holder string
+	Before *lock.Semaphore
+	After  *lock.Semaphore
+}
+
+// subscribeRetry is how long Subscribe backs off after a failed watch.
+const subscribeRetry = time.Second
+
+// Subscribe streams changes to the Semaphore as Events, built on a single
+// long-poll of the KV.  The channel is closed once ctx is done, or after a
+// Deleted event.  Locks and unlocks that land between two polls are
+// replayed from the semaphore's History, so a hold that starts and ends in
+// that window is still seen, unless more than the History keeps happened;
+// other changes are reported as the net difference.  Cancelling ctx takes
+// effect when the current long-poll returns.
+func (s *Semaphore) Subscribe(ctx context.Context) (<-chan Event, error) {
+	cur, err := s.lock.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	events := make(chan Event)
+	go s.subscribe(ctx, cur, events)
+	return events, nil
+}
+
+func (s *Semaphore) subscribe(ctx context.Context, cur *lock.Semaphore, events chan<- Event) {
+	defer close(events)
+
+	for ctx.Err() == nil {
+		next, err := s.lock.WatchFrom(cur)
+		switch {
+		case err == lock.SemaphoreNotFoundErr:
+			send(ctx, events, Event{Type: Deleted, Before: cur})
+			return
+		case err != nil:
+			s.log.Log(logging.Warn, "subscribe watch failed, retrying", logging.F("error", err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(subscribeRetry):
+			}
+			continue
+		}
+
+		if next.Index == cur.Index {
+			continue
+		}
+
+		for _, e := range diff(cur, next) {
+			if !send(ctx, events, e) {
+				return
+			}
+		}
+		cur = next
+	}
+}
+
+// Evicted watches for the Semaphore's Holder being evicted, delivering the
+// audit entry describing the eviction.  The channel is closed once ctx is
+// done or the Semaphore is deleted.
+func (s *Semaphore) Evicted(ctx context.Context) (<-chan lock.AuditEntry, error) {
+	events, err := s.Subscribe(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	evictions := make(chan lock.AuditEntry)
+	go func() {
+		defer close(evictions)
+		for e := range events {
+			if e.Type != HolderRemoved || e.Holder != s.Holder {
+				continue
+			}
+			if revoked, ok := e.After.Revoked[s.Holder]; ok {
+				select {
+				case evictions <- revoked:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	return evictions, nil
+}
+
+// send delivers e unless ctx is done first, reporting whether it was sent.
+func send(ctx context.Context, events chan<- Event, e Event) bool {
+	select {
+	case events <- e:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// diff returns the Events that turn before into after.  Holder events are
+// replayed from after's History where it picks up from before's, and the
+// net difference makes up for the rest.
+func diff(before, after *lock.Semaphore) (events []Event) {
+	event := func(t EventType, holder string) {
+		events = append(events, Event{t, holder, before, after})
+	}
+
+	if before.Max != after.Max {
+		event(MaxChanged, "")
+	}
+
+	holders := append([]string{}, before.Holders...)
+	for _, e := range since(after.History, before.HistorySeq()) {
+		switch e.Action {
+		case "lock":
+			event(HolderAdded, e.Holder)
+			holders = append(holders, e.Holder)
+		case "unlock":
+			event(HolderRemoved, e.Holder)
+			holders = missing(holders, []string{e.Holder})
+		}
+	}
+
+	for _, h := range missing(holders, after.Holders) {
+		event(HolderRemoved, h)
+	}
+	for _, h := range missing(after.Holders, holders) {
+		event(HolderAdded, h)
+	}
+	for _, h := range missing(after.Waiters, before.Waiters) {
+		event(WaiterEnqueued, h)
+	}
+
+	return events
+}
+
+// since returns the entries of history after seq, or none if some of them
+// have already been dropped.
+func since(history []lock.HistoryEntry, seq uint64) []lock.HistoryEntry {
+	for i, e := range history {
+		if e.Seq > seq {
+			if e.Seq != seq+1 {
+				return nil
+			}
+			return history[i:]
+		}
+	}
+	return nil
+}
+
+// missing returns the entries of a that are not in b.
+func missing(a, b []string) (m []string) {
+	seen := make(map[string]bool, len(b))
+	for _, s := range b {
+		seen[s] = true
+	}
+	for _, s := range a {
+		if !seen[s] {
+			m = append(m, s)
+		}
+	}
+	return m
+}
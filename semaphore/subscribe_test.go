@@ -0,0 +1,87 @@
+package semaphore
+
+import (
+	"reflect"
+	"testing"
+
+	lock "github.com/ryanschneider/consul-semaphore/lock"
+)
+
+func TestDiff(t *testing.T) {
+	before := &lock.Semaphore{Max: 2, Semaphore: 1, Holders: []string{"a"}}
+	after := &lock.Semaphore{Max: 3, Semaphore: 2, Holders: []string{"b"}, Waiters: []string{"c"}}
+
+	var got []EventType
+	var holders []string
+	for _, e := range diff(before, after) {
+		if e.Before != before || e.After != after {
+			t.Error("event does not carry the before and after state", e)
+		}
+		got = append(got, e.Type)
+		holders = append(holders, e.Holder)
+	}
+
+	want := []EventType{MaxChanged, HolderRemoved, HolderAdded, WaiterEnqueued}
+	if !reflect.DeepEqual(got, want) {
+		t.Error("unexpected events", got)
+	}
+	if !reflect.DeepEqual(holders, []string{"", "a", "b", "c"}) {
+		t.Error("unexpected event holders", holders)
+	}
+
+	if events := diff(after, after); len(events) != 0 {
+		t.Error("unchanged semaphore produced events", events)
+	}
+}
+
+func TestDiffReplaysHistory(t *testing.T) {
+	history := []lock.HistoryEntry{
+		{Seq: 1, Action: "lock", Holder: "a"},
+		{Seq: 2, Action: "lock", Holder: "b"},
+		{Seq: 3, Action: "unlock", Holder: "b"},
+		{Seq: 4, Action: "unlock", Holder: "a"},
+		{Seq: 5, Action: "lock", Holder: "c"},
+	}
+	before := &lock.Semaphore{Max: 2, Semaphore: 1, Holders: []string{"a"}, History: history[:1]}
+
+	tests := []struct {
+		name    string
+		after   *lock.Semaphore
+		events  []EventType
+		holders []string
+	}{
+		{
+			name:    "replayed",
+			after:   &lock.Semaphore{Max: 2, Semaphore: 1, Holders: []string{"c"}, History: history},
+			events:  []EventType{HolderAdded, HolderRemoved, HolderRemoved, HolderAdded},
+			holders: []string{"b", "b", "a", "c"},
+		},
+		{
+			// the entries since before were dropped, so only the net
+			// difference is known
+			name:    "history dropped",
+			after:   &lock.Semaphore{Max: 2, Semaphore: 1, Holders: []string{"c"}, History: history[2:]},
+			events:  []EventType{HolderRemoved, HolderAdded},
+			holders: []string{"a", "c"},
+		},
+		{
+			// a holder locked without recording history
+			name:    "unrecorded",
+			after:   &lock.Semaphore{Max: 2, Semaphore: 0, Holders: []string{"a", "d"}, History: history[:1]},
+			events:  []EventType{HolderAdded},
+			holders: []string{"d"},
+		},
+	}
+
+	for _, test := range tests {
+		var got []EventType
+		var holders []string
+		for _, e := range diff(before, test.after) {
+			got = append(got, e.Type)
+			holders = append(holders, e.Holder)
+		}
+		if !reflect.DeepEqual(got, test.events) || !reflect.DeepEqual(holders, test.holders) {
+			t.Errorf("%s: got %v for %v, want %v for %v", test.name, got, holders, test.events, test.holders)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package server exposes semaphores over HTTP with JSON bodies, for
+// clients that would rather not speak Consul's KV and the semaphore's
+// check-and-set protocol themselves.
+//
+//	GET /v1/semaphore/{path}            the semaphore's state; with
+//	                                    ?index=N, blocks until it differs
+//	                                    from index N
+//	PUT /v1/semaphore/{path}/acquire    ?holder=H, optionally &wait=true
+//	                                    and &timeout=30s
+//	PUT /v1/semaphore/{path}/release    ?holder=H
+//	PUT /v1/semaphore/{path}/max        ?max=N, optionally &reason=R and
+//	                                    &holder=H, recorded as the actor
+//
+// Only paths under the Server's Prefix are served, and keys that hold
+// anything but a semaphore are refused rather than overwritten.  Failures
+// are answered with an agent.Error.
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	api "github.com/armon/consul-api"
+	"github.com/ryanschneider/consul-semaphore/agent"
+	"github.com/ryanschneider/consul-semaphore/backoff"
+	"github.com/ryanschneider/consul-semaphore/lock"
+	"github.com/ryanschneider/consul-semaphore/logging"
+	"github.com/ryanschneider/consul-semaphore/semaphore"
+)
+
+// prefix is where semaphores are served.
+const prefix = "/v1/semaphore/"
+
+// IndexHeader carries the semaphore's index, to pass back as ?index=.
+const IndexHeader = "X-Semaphore-Index"
+
+// DefaultActor is recorded as the actor of max changes made without a
+// holder.
+const DefaultActor = "serve"
+
+// Server serves the semaphores stored through Client under Prefix, which
+// should be set, as otherwise every key is open to clients.  If Token is
+// set, requests must carry it as a bearer token.  Log defaults to
+// logging.Default(), and Backoff to backoff.Default.
+type Server struct {
+	Client  *api.Client
+	Prefix  string
+	Token   string
+	Log     logging.Logger
+	Backoff backoff.Policy
+}
+
+func (s *Server) logger() logging.Logger {
+	if s.Log == nil {
+		return logging.Default()
+	}
+	return s.Log
+}
+
+// errBadRequest is wrapped by errors caused by the request itself.
+var errBadRequest = errors.New("bad request")
+
+func badRequest(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: %s", errBadRequest, fmt.Sprintf(format, args...))
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		s.fail(w, http.StatusUnauthorized, agent.Error{Kind: agent.KindOther, Message: "unauthorized"})
+		return
+	}
+
+	path, op := split(r.URL.Path)
+	if path == "" {
+		s.fail(w, http.StatusNotFound, agent.Error{Kind: agent.KindOther, Message: "no semaphore path given"})
+		return
+	}
+	if !s.within(path) {
+		s.fail(w, http.StatusForbidden, agent.Error{Kind: agent.KindOther, Message: fmt.Sprintf("%s is not under %s", path, s.Prefix)})
+		return
+	}
+
+	var (
+		result interface{}
+		err    error
+	)
+	switch {
+	case op == "" && r.Method == "GET":
+		result, err = s.get(w, r, path)
+	case op == "acquire" && r.Method == "PUT":
+		result, err = s.acquire(r, path)
+	case op == "release" && r.Method == "PUT":
+		result, err = s.release(r, path)
+	case op == "max" && r.Method == "PUT":
+		result, err = s.max(r, path)
+	default:
+		w.Header().Set("Allow", allowed(op))
+		s.fail(w, http.StatusMethodNotAllowed, agent.Error{Kind: agent.KindOther, Message: "method not allowed"})
+		return
+	}
+
+	if err != nil {
+		s.fail(w, status(err), agent.NewError(err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// authorized checks the request's bearer token.
+func (s *Server) authorized(r *http.Request) bool {
+	if s.Token == "" {
+		return true
+	}
+
+	token := r.Header.Get("Authorization")
+	if !strings.HasPrefix(token, "Bearer ") {
+		return false
+	}
+	token = strings.TrimPrefix(token, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) == 1
+}
+
+// within reports whether path falls under s.Prefix.  Paths with empty, "."
+// or ".." segments are refused outright, so none can climb out of it.
+func (s *Server) within(path string) bool {
+	for _, segment := range strings.Split(path, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return false
+		}
+	}
+
+	prefix := strings.TrimSuffix(s.Prefix, "/")
+	if prefix == "" {
+		return true
+	}
+	return path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// split splits a request path into the semaphore's path and the operation.
+func split(p string) (path, op string) {
+	if !strings.HasPrefix(p, prefix) {
+		return "", ""
+	}
+	path = strings.TrimPrefix(p, prefix)
+
+	for _, o := range []string{"acquire", "release", "max"} {
+		if strings.HasSuffix(path, "/"+o) {
+			return strings.TrimSuffix(path, "/"+o), o
+		}
+	}
+	return path, ""
+}
+
+func allowed(op string) string {
+	if op == "" {
+		return "GET"
+	}
+	return "PUT"
+}
+
+// status picks the HTTP status for err.
+func status(err error) int {
+	if errors.Is(err, errBadRequest) {
+		return http.StatusBadRequest
+	}
+	if err == lock.SemaphoreNotFoundErr {
+		return http.StatusNotFound
+	}
+
+	switch agent.NewError(err).Kind {
+	case agent.KindExhausted:
+		return http.StatusConflict
+	case agent.KindTimeout:
+		return http.StatusRequestTimeout
+	case agent.KindNotHeld:
+		return http.StatusNotFound
+	case agent.KindUnreachable:
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
+}
+
+func (s *Server) fail(w http.ResponseWriter, code int, e agent.Error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(e)
+}
+
+// get returns the semaphore at path without creating it, long-polling if
+// an index is given.
+func (s *Server) get(w http.ResponseWriter, r *http.Request, path string) (interface{}, error) {
+	client, err := lock.NewConsulLockClient(s.Client)
+	if err != nil {
+		return nil, err
+	}
+	client.SetPath(path)
+
+	var sem *lock.Semaphore
+	if index := r.URL.Query().Get("index"); index != "" {
+		i, err := strconv.ParseUint(index, 10, 64)
+		if err != nil {
+			return nil, badRequest("index: %s", err)
+		}
+		sem = &lock.Semaphore{Index: i}
+		if _, err = client.Watch(sem); err != nil {
+			return nil, err
+		}
+	} else if sem, err = client.Get(); err != nil {
+		return nil, err
+	}
+
+	w.Header().Set(IndexHeader, strconv.FormatUint(sem.Index, 10))
+	return sem, nil
+}
+
+// holder returns the semaphore at path for the request's holder, creating
+// it if there is no key at path, but refusing a key that holds anything
+// else.
+func (s *Server) holder(r *http.Request, path string, fallback string) (*semaphore.Semaphore, error) {
+	holder := r.URL.Query().Get("holder")
+	if holder == "" {
+		holder = fallback
+	}
+	if holder == "" {
+		return nil, badRequest("holder is required")
+	}
+
+	pair, _, err := s.Client.KV().Get(path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if pair != nil {
+		if _, err := lock.ParseSemaphore(pair.Value); err != nil {
+			return nil, badRequest("%s: %s", path, err)
+		}
+	}
+
+	sem, err := semaphore.NewWithClient(path, holder, s.Client)
+	if err != nil {
+		return nil, err
+	}
+	sem.SetLogger(s.logger())
+	sem.SetBackoff(s.Backoff)
+	return sem, nil
+}
+
+func (s *Server) acquire(r *http.Request, path string) (interface{}, error) {
+	sem, err := s.holder(r, path, "")
+	if err != nil {
+		return nil, err
+	}
+
+	q := r.URL.Query()
+	wait := q.Get("wait") == "true"
+	ctx := r.Context()
+	if t := q.Get("timeout"); t != "" {
+		timeout, err := time.ParseDuration(t)
+		if err != nil {
+			return nil, badRequest("timeout: %s", err)
+		}
+		wait = true
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	// ctx is cancelled if the client goes away while waiting
+	if err := sem.AcquireContext(ctx, wait); err != nil {
+		return nil, err
+	}
+
+	s.logger().Log(logging.Info, "served acquire", logging.F("path", path), logging.F("holder", sem.Holder))
+	result := map[string]interface{}{"path": path, "holder": sem.Holder}
+	if slot := sem.Slot(); slot >= 0 {
+		result["slot"] = slot
+	}
+	return result, nil
+}
+
+func (s *Server) release(r *http.Request, path string) (interface{}, error) {
+	sem, err := s.holder(r, path, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sem.Release(); err != nil {
+		return nil, err
+	}
+
+	s.logger().Log(logging.Info, "served release", logging.F("path", path), logging.F("holder", sem.Holder))
+	return map[string]interface{}{"path": path, "holder": sem.Holder}, nil
+}
+
+func (s *Server) max(r *http.Request, path string) (interface{}, error) {
+	q := r.URL.Query()
+	max, err := strconv.ParseUint(q.Get("max"), 10, 0)
+	if err != nil {
+		return nil, badRequest("max: %s", err)
+	}
+
+	sem, err := s.holder(r, path, DefaultActor)
+	if err != nil {
+		return nil, err
+	}
+
+	change, err := sem.ChangeMax(uint(max), q.Get("reason"))
+	if err != nil {
+		return nil, err
+	}
+
+	s.logger().Log(logging.Info, "served max change", logging.F("path", path), logging.F("holder", sem.Holder),
+		logging.F("old", change.Old), logging.F("new", change.New))
+	return map[string]interface{}{
+		"path":    path,
+		"old":     change.Old,
+		"new":     change.New,
+		"holders": change.Holders,
+		"over":    change.Over,
+	}, nil
+}
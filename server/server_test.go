@@ -0,0 +1,78 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSplit(t *testing.T) {
+	tests := []struct {
+		url, path, op string
+	}{
+		{"/v1/semaphore/global/semaphore", "global/semaphore", ""},
+		{"/v1/semaphore/global/semaphore/acquire", "global/semaphore", "acquire"},
+		{"/v1/semaphore/a/b/c/release", "a/b/c", "release"},
+		{"/v1/semaphore/web/max", "web", "max"},
+		{"/v1/other/web", "", ""},
+	}
+
+	for _, test := range tests {
+		path, op := split(test.url)
+		if path != test.path || op != test.op {
+			t.Errorf("%s: got %q, %q, want %q, %q", test.url, path, op, test.path, test.op)
+		}
+	}
+}
+
+func TestServeHTTPRejects(t *testing.T) {
+	s := &Server{Prefix: "locks/", Token: "secret"}
+
+	tests := []struct {
+		method, url, token string
+		code               int
+	}{
+		{"GET", "/v1/semaphore/locks/web", "", http.StatusUnauthorized},
+		{"GET", "/v1/semaphore/locks/web", "wrong", http.StatusUnauthorized},
+		{"GET", "/v1/semaphore/", "secret", http.StatusNotFound},
+		{"GET", "/v1/semaphore/config/web", "secret", http.StatusForbidden},
+		{"PUT", "/v1/semaphore/locks/../config/acquire?holder=a", "secret", http.StatusForbidden},
+		{"PUT", "/v1/semaphore/locks/web/../../config/max?max=3", "secret", http.StatusForbidden},
+		{"GET", "/v1/semaphore/locks/./web", "secret", http.StatusForbidden},
+		{"GET", "/v1/semaphore/locks//web", "secret", http.StatusForbidden},
+		{"GET", "/v1/semaphore/locks/web/", "secret", http.StatusForbidden},
+		{"PUT", "/v1/semaphore/locksmith/max?max=3", "secret", http.StatusForbidden},
+		{"POST", "/v1/semaphore/locks/web/acquire", "secret", http.StatusMethodNotAllowed},
+		{"PUT", "/v1/semaphore/locks/web", "secret", http.StatusMethodNotAllowed},
+		{"PUT", "/v1/semaphore/locks/web/max?max=lots", "secret", http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.url, nil)
+		if test.token != "" {
+			r.Header.Set("Authorization", "Bearer "+test.token)
+		}
+		w := httptest.NewRecorder()
+		s.ServeHTTP(w, r)
+		if w.Code != test.code {
+			t.Errorf("%s %s: got %d, want %d", test.method, test.url, w.Code, test.code)
+		}
+	}
+}
+
+func TestAuthorizedNeedsScheme(t *testing.T) {
+	s := &Server{Token: "secret"}
+
+	for header, want := range map[string]bool{
+		"Bearer secret": true,
+		"secret":        false,
+		"Basic secret":  false,
+		"Bearer ":       false,
+	} {
+		r := httptest.NewRequest("GET", "/v1/semaphore/web", nil)
+		r.Header.Set("Authorization", header)
+		if got := s.authorized(r); got != want {
+			t.Errorf("Authorization %q: got %v, want %v", header, got, want)
+		}
+	}
+}